@@ -0,0 +1,150 @@
+package main
+
+import "math"
+
+// vec is a point in output units.
+type vec struct {
+	x, y float64
+}
+
+// minArcPoints is the fewest points a run must have before it is worth
+// replacing with an arc; three points always lie on some circle.
+const minArcPoints = 4
+
+// fitArcs turns the polyline pts into cut and arc moves, replacing each
+// run of points that lies within tolerance of a common circle with a single
+// arc to the run's last point. Runs that are nearly straight stay as cuts.
+// The moves start from pts[0], which the caller has already moved to.
+func fitArcs(pts []vec, tolerance float64) []Move {
+	var moves []Move
+
+	for i := 0; i < len(pts)-1; {
+		end := i + 1
+		var best Move
+		var bent bool
+
+		// Grow the run one point at a time and keep the longest that
+		// still lies on a circle; a run that breaks rarely fits again
+		// further on. A gentle curve only bends away from its chord once
+		// it is long enough, so that is decided for the whole run.
+		for j := i + minArcPoints - 1; j < len(pts); j++ {
+			// A run straight so far has no circle to check yet.
+			if _, _, ok := circumcenter(pts[i], pts[(i+j+1)/2], pts[j]); !ok {
+				continue
+			}
+			m, b, ok := fitArc(pts[i:j+1], tolerance)
+			if !ok {
+				break
+			}
+			end, best, bent = j, m, b
+		}
+
+		if !bent {
+			end = i + 1
+			moves = append(moves, Move{Type: MoveCut, X: pts[end].x, Y: pts[end].y})
+		} else {
+			moves = append(moves, best)
+		}
+		i = end
+	}
+
+	return moves
+}
+
+// fitArc reports whether run lies on one circle within tolerance, turning
+// the same way throughout and bulging no more than tolerance from any of its
+// segments, and if so returns the arc move from run[0] to its last point and
+// whether the run bends more than tolerance away from its chord, short of
+// which a straight cut would do.
+func fitArc(run []vec, tolerance float64) (Move, bool, bool) {
+	first, last := run[0], run[len(run)-1]
+	cx, cy, ok := circumcenter(first, run[len(run)/2], last)
+	if !ok {
+		return Move{}, false, false
+	}
+
+	radius := math.Hypot(first.x-cx, first.y-cy)
+	bent := false
+	turn := 0.0
+	sweep := 0.0
+
+	for k, p := range run {
+		if math.Abs(math.Hypot(p.x-cx, p.y-cy)-radius) > tolerance {
+			return Move{}, false, false
+		}
+		if distanceToLine(p, first, last) > tolerance {
+			bent = true
+		}
+		if k == 0 {
+			continue
+		}
+
+		// Every step must turn the same way around the centre, so the arc
+		// doesn't double back on itself.
+		prev := run[k-1]
+		cross := (prev.x-cx)*(p.y-cy) - (prev.y-cy)*(p.x-cx)
+		if cross == 0 || (turn != 0 && math.Signbit(cross) != math.Signbit(turn)) {
+			return Move{}, false, false
+		}
+		turn = cross
+
+		// The arc must also stay near each segment it replaces, or a few
+		// far-apart corners that happen to share a circle would pass.
+		half := math.Hypot(p.x-prev.x, p.y-prev.y) / 2
+		if radius-math.Sqrt(math.Max(0, radius*radius-half*half)) > tolerance {
+			return Move{}, false, false
+		}
+
+		dot := (prev.x-cx)*(p.x-cx) + (prev.y-cy)*(p.y-cy)
+		sweep += math.Abs(math.Atan2(cross, dot))
+	}
+
+	// A full circle's end point equals its start, which G2/G3 can't
+	// express unambiguously, so stop short of one.
+	if sweep >= 2*math.Pi-0.1 {
+		return Move{}, false, false
+	}
+
+	t := MoveArcCCW
+	if turn < 0 {
+		t = MoveArcCW
+	}
+	return Move{Type: t, X: last.x, Y: last.y, I: cx - first.x, J: cy - first.y}, bent, true
+}
+
+// circumcenter returns the centre of the circle through a, b and c, or
+// false when they are collinear.
+func circumcenter(a, b, c vec) (float64, float64, bool) {
+	d := 2 * (a.x*(b.y-c.y) + b.x*(c.y-a.y) + c.x*(a.y-b.y))
+	if math.Abs(d) < 1e-12 {
+		return 0, 0, false
+	}
+
+	a2 := a.x*a.x + a.y*a.y
+	b2 := b.x*b.x + b.y*b.y
+	c2 := c.x*c.x + c.y*c.y
+	x := (a2*(b.y-c.y) + b2*(c.y-a.y) + c2*(a.y-b.y)) / d
+	y := (a2*(c.x-b.x) + b2*(a.x-c.x) + c2*(b.x-a.x)) / d
+	return x, y, true
+}
+
+// distanceToLine returns the distance from p to the line through a and b.
+func distanceToLine(p, a, b vec) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		return math.Hypot(p.x-a.x, p.y-a.y)
+	}
+	return math.Abs(dx*(p.y-a.y)-dy*(p.x-a.x)) / length
+}
+
+// distanceToSegment returns the distance from p to the segment from a to b.
+func distanceToSegment(p, a, b vec) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	lengthSq := dx*dx + dy*dy
+	if lengthSq == 0 {
+		return math.Hypot(p.x-a.x, p.y-a.y)
+	}
+	t := math.Max(0, math.Min(1, ((p.x-a.x)*dx+(p.y-a.y)*dy)/lengthSq))
+	return math.Hypot(p.x-(a.x+t*dx), p.y-(a.y+t*dy))
+}
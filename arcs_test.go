@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// quarterCircle samples n+1 points along a quarter circle of radius r
+// around the origin, from (r, 0) to (0, r).
+func quarterCircle(n int, r float64) []vec {
+	pts := make([]vec, n+1)
+	for i := range pts {
+		a := float64(i) / float64(n) * math.Pi / 2
+		pts[i] = vec{r * math.Cos(a), r * math.Sin(a)}
+	}
+	return pts
+}
+
+func TestFitArcsQuarterCircle(t *testing.T) {
+	for _, n := range []int{20, 50, 100} {
+		moves := fitArcs(quarterCircle(n, 50), 0.05)
+		if len(moves) != 1 {
+			t.Fatalf("%d points: got %d moves, want a single arc", n, len(moves))
+		}
+		m := moves[0]
+		if m.Type != MoveArcCCW {
+			t.Errorf("%d points: move is %s, want %s", n, m.Type, MoveArcCCW)
+		}
+		if math.Abs(m.X) > 1e-9 || math.Abs(m.Y-50) > 1e-9 {
+			t.Errorf("%d points: arc ends at %g, %g, want 0, 50", n, m.X, m.Y)
+		}
+		// The centre is the origin, relative to the start at (50, 0).
+		if math.Abs(m.I+50) > 1e-6 || math.Abs(m.J) > 1e-6 {
+			t.Errorf("%d points: arc centre offset %g, %g, want -50, 0", n, m.I, m.J)
+		}
+	}
+}
+
+func TestFitArcsClockwise(t *testing.T) {
+	pts := quarterCircle(50, 50)
+	reversed := reverseVecs(pts)
+	moves := fitArcs(reversed, 0.05)
+	if len(moves) != 1 || moves[0].Type != MoveArcCW {
+		t.Fatalf("got %+v, want a single clockwise arc", moves)
+	}
+}
+
+func TestFitArcsLeavesStraightLinesAlone(t *testing.T) {
+	pts := []vec{{0, 0}, {1, 0}, {2, 0}, {3, 0}, {4, 0}, {5, 0}}
+	moves := fitArcs(pts, 0.05)
+	if len(moves) != len(pts)-1 {
+		t.Fatalf("got %d moves, want %d cuts", len(moves), len(pts)-1)
+	}
+	for _, m := range moves {
+		if m.Type != MoveCut {
+			t.Errorf("got a %s move on a straight line", m.Type)
+		}
+	}
+}
+
+func TestFitArcsStopsAtCorner(t *testing.T) {
+	// A quarter circle followed by a straight run away from its end: the
+	// arc must stop at the corner and the run stay as cuts.
+	pts := quarterCircle(50, 50)
+	for i := 1; i <= 5; i++ {
+		pts = append(pts, vec{-float64(i) * 5, 50})
+	}
+	moves := fitArcs(pts, 0.05)
+	if len(moves) == 0 || moves[0].Type != MoveArcCCW {
+		t.Fatalf("first of %d moves is not an arc", len(moves))
+	}
+	if moves[0].X > 1e-9 || math.Abs(moves[0].Y-50) > 1e-9 {
+		t.Errorf("arc ends at %g, %g, want the corner at 0, 50", moves[0].X, moves[0].Y)
+	}
+	last := moves[len(moves)-1]
+	if last.Type != MoveCut || last.X != -25 || last.Y != 50 {
+		t.Errorf("last move %+v, want a cut to -25, 50", last)
+	}
+}
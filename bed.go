@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrOutsideBed is returned, wrapped with the offending position, when a
+// job moves the head beyond Config.BedWidth or Config.BedHeight and
+// Config.ClipToBed is not set.
+var ErrOutsideBed = errors.New("job runs outside the machine bed")
+
+// bedGuard checks moves against the machine bed as they are emitted, from
+// 0 to width along X and 0 to height along Y. A nil guard lets everything
+// through.
+type bedGuard struct {
+	width, height float64
+	clip          bool
+	cnc           bool
+	safeZ         float64
+
+	engaged bool  // the tool is on or down
+	on      Move  // the move that engaged the tool
+	skipped bool  // moves have been dropped since the head was last inside
+	err     error // the first move outside the bed, when not clipping
+}
+
+// newBedGuard returns the guard for cfg's bed, or nil when no bed size is
+// set.
+func newBedGuard(cfg Config) *bedGuard {
+	if cfg.BedWidth <= 0 && cfg.BedHeight <= 0 {
+		return nil
+	}
+	return &bedGuard{
+		width:  cfg.BedWidth,
+		height: cfg.BedHeight,
+		clip:   cfg.ClipToBed,
+		cnc:    cfg.CNC,
+		safeZ:  cfg.SafeZ,
+	}
+}
+
+// add passes m to emit unless it ends outside the bed. When clipping, the
+// first move back inside becomes a travel, with the tool disengaged before
+// it and engaged again after it just as it was, so nothing is cut on the
+// way; otherwise the first move outside is recorded in err and the rest of
+// the job is dropped. Arcs are judged by their end points, as for the
+// job's extents.
+func (g *bedGuard) add(m Move, emit func(Move)) {
+	if g == nil {
+		emit(m)
+		return
+	}
+	if g.err != nil {
+		return
+	}
+
+	switch m.Type {
+	case MoveLaserOn, MovePlunge:
+		g.engaged, g.on = true, m
+	case MoveLaserOff, MoveRetract:
+		g.engaged = false
+	}
+	if !m.positioned() {
+		emit(m)
+		return
+	}
+
+	if g.outside(m.X, m.Y) {
+		if !g.clip {
+			g.err = fmt.Errorf("%w: move to X%.3f Y%.3f", ErrOutsideBed, m.X, m.Y)
+		}
+		g.skipped = true
+		return
+	}
+
+	if g.skipped {
+		g.skipped = false
+		if g.engaged {
+			if g.cnc {
+				emit(Move{Type: MoveRetract, Z: g.safeZ})
+			} else {
+				emit(Move{Type: MoveLaserOff})
+			}
+			emit(Move{Type: MoveTravel, X: m.X, Y: m.Y})
+			emit(g.on)
+			return
+		}
+		m = Move{Type: MoveTravel, X: m.X, Y: m.Y}
+	}
+	emit(m)
+}
+
+// filterMoves runs every move of a built toolpath through the guard.
+func (g *bedGuard) filterMoves(moves []Move) ([]Move, error) {
+	if g == nil {
+		return moves, nil
+	}
+	kept := make([]Move, 0, len(moves))
+	for _, m := range moves {
+		g.add(m, func(m Move) { kept = append(kept, m) })
+	}
+	return kept, g.err
+}
+
+// outside reports whether x, y lies beyond the bed on an axis with a size.
+func (g *bedGuard) outside(x, y float64) bool {
+	const slack = 1e-9
+	if g.width > 0 && (x < -slack || x > g.width+slack) {
+		return true
+	}
+	return g.height > 0 && (y < -slack || y > g.height+slack)
+}
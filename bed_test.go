@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBedGuardClipDisengagesOnReentry(t *testing.T) {
+	on := Move{Type: MoveLaserOn, Power: 1000}
+	moves := []Move{
+		{Type: MoveTravel, X: 10, Y: 10},
+		on,
+		{Type: MoveCut, X: 40, Y: 10},
+		{Type: MoveCut, X: 60, Y: 10},
+		{Type: MoveCut, X: 60, Y: 20},
+		{Type: MoveCut, X: 40, Y: 20},
+		{Type: MoveCut, X: 10, Y: 20},
+		{Type: MoveLaserOff},
+	}
+	g := newBedGuard(Config{BedWidth: 50, BedHeight: 50, ClipToBed: true})
+	got, err := g.filterMoves(moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Move{
+		{Type: MoveTravel, X: 10, Y: 10},
+		on,
+		{Type: MoveCut, X: 40, Y: 10},
+		{Type: MoveLaserOff},
+		{Type: MoveTravel, X: 40, Y: 20},
+		on,
+		{Type: MoveCut, X: 10, Y: 20},
+		{Type: MoveLaserOff},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestBedGuardClipRetractsInCNCMode(t *testing.T) {
+	plunge := Move{Type: MovePlunge, Z: -1}
+	moves := []Move{
+		plunge,
+		{Type: MoveCut, X: 60, Y: 10},
+		{Type: MoveCut, X: 40, Y: 20},
+	}
+	g := newBedGuard(Config{BedWidth: 50, ClipToBed: true, CNC: true, SafeZ: 5})
+	got, err := g.filterMoves(moves)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Move{
+		plunge,
+		{Type: MoveRetract, Z: 5},
+		{Type: MoveTravel, X: 40, Y: 20},
+		plunge,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got  %+v\nwant %+v", got, want)
+	}
+}
+
+func TestBedGuardClipReentryWithToolOff(t *testing.T) {
+	moves := []Move{
+		{Type: MoveTravel, X: 60, Y: 10},
+		{Type: MoveTravel, X: 40, Y: 20},
+	}
+	g := newBedGuard(Config{BedWidth: 50, ClipToBed: true})
+	got, _ := g.filterMoves(moves)
+	want := []Move{{Type: MoveTravel, X: 40, Y: 20}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestBedGuardRejectsWithoutClip(t *testing.T) {
+	moves := []Move{
+		{Type: MoveCut, X: 10, Y: 10},
+		{Type: MoveCut, X: 60, Y: 10},
+		{Type: MoveCut, X: 10, Y: 20},
+	}
+	_, err := newBedGuard(Config{BedWidth: 50}).filterMoves(moves)
+	if !errors.Is(err, ErrOutsideBed) {
+		t.Fatalf("err = %v, want ErrOutsideBed", err)
+	}
+}
+
+func TestOffsetBeyondBed(t *testing.T) {
+	img := rect(40, 40, 5, 5, 35, 35)
+	cfg := DefaultConfig()
+	cfg.Width, cfg.Height = 40, 40
+	cfg.OffsetX = 30
+	cfg.BedWidth, cfg.BedHeight = 50, 50
+	if _, err := ConvertToGCode(img, cfg); !errors.Is(err, ErrOutsideBed) {
+		t.Fatalf("err = %v, want ErrOutsideBed", err)
+	}
+
+	cfg.ClipToBed = true
+	gcode, err := ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cuts int
+	for _, line := range strings.Split(gcode, "\n") {
+		var x, y float64
+		if _, err := fmt.Sscanf(line[min(3, len(line)):], "X%f Y%f", &x, &y); err != nil {
+			continue
+		}
+		if x < 0 || x > 50 || y < 0 || y > 50 {
+			t.Errorf("clipped program leaves the bed: %s", line)
+		}
+		if strings.HasPrefix(line, "G1") {
+			cuts++
+		}
+	}
+	if cuts == 0 {
+		t.Error("clipping dropped the part inside the bed too")
+	}
+}
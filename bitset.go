@@ -0,0 +1,17 @@
+package main
+
+// bitset is a packed set of bits, one per pixel indexed by y*width+x, for
+// the visited maps of full-image scans.
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) get(i int) bool {
+	return b[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+func (b bitset) set(i int) {
+	b[i/64] |= 1 << (uint(i) % 64)
+}
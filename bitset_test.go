@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestBitset(t *testing.T) {
+	const n = 200
+	b := newBitset(n)
+	if len(b) != 4 {
+		t.Errorf("%d bits take %d words, want 4", n, len(b))
+	}
+	set := func(i int) bool { return i%3 == 0 || i == 63 || i == 64 || i == n-1 }
+	for i := 0; i < n; i++ {
+		if b.get(i) {
+			t.Fatalf("bit %d set in a new bitset", i)
+		}
+		if set(i) {
+			b.set(i)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if b.get(i) != set(i) {
+			t.Errorf("bit %d is %v, want %v", i, b.get(i), set(i))
+		}
+	}
+	b.set(0)
+	if !b.get(0) || b.get(1) {
+		t.Error("setting a bit twice disturbed its neighbours")
+	}
+}
+
+func BenchmarkVisited(b *testing.B) {
+	const n = 1000 * 1000
+	b.Run("bitset", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			v := newBitset(n)
+			for j := 0; j < n; j += 3 {
+				v.set(j)
+			}
+		}
+	})
+	b.Run("bool slice", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			v := make([]bool, n)
+			for j := 0; j < n; j += 3 {
+				v[j] = true
+			}
+		}
+	})
+	b.Run("map", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			v := map[int]bool{}
+			for j := 0; j < n; j += 3 {
+				v[j] = true
+			}
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+// mooreNeighbors lists the eight neighbours of a pixel clockwise (with y
+// pointing down), starting from the west.
+var mooreNeighbors = [8]Point{
+	{-1, 0}, {-1, -1}, {0, -1}, {1, -1},
+	{1, 0}, {1, 1}, {0, 1}, {-1, 1},
+}
+
+// traceRegionBoundary walks the outer boundary of a region with Moore
+// neighbour tracing and returns it as a closed path, starting and ending
+// at the region's top-left pixel. Holes are not traced.
+func traceRegionBoundary(points []Point) Path {
+	if len(points) == 0 {
+		return Path{}
+	}
+
+	inside := make(map[Point]bool, len(points))
+	start := points[0]
+	for _, p := range points {
+		inside[p] = true
+		if p.y < start.y || (p.y == start.y && p.x < start.x) {
+			start = p
+		}
+	}
+
+	// The pixel west of the top-left pixel is always outside, so it is a
+	// valid place to have entered from.
+	inRegion := func(p Point) bool { return inside[p] }
+	return Path{points: mooreTrace(start, 0, inRegion, nil, 4*len(points))}
+}
+
+// mooreTrace follows the boundary of the shape that inside describes
+// clockwise from start, having entered it from the neighbour in direction
+// from, which must be outside. It returns the pixels walked, closed back to
+// start. The walk ends when it is about to leave start the same way it
+// first did, so one-pixel necks through the start are not cut short; when
+// it repeats a pixel and entry direction, which happens when start is an
+// inner corner off the loop; before stepping onto a pixel for which stop
+// reports true; or after limit steps.
+func mooreTrace(start Point, from int, inside, stop func(Point) bool, limit int) []Point {
+	points := []Point{start}
+	cur := start
+	firstStep := -1
+
+	type state struct {
+		p    Point
+		from int
+	}
+	seen := map[state]bool{}
+
+	for ; limit > 0; limit-- {
+		if seen[state{cur, from}] {
+			break
+		}
+		seen[state{cur, from}] = true
+
+		next := -1
+		for k := 1; k <= 8; k++ {
+			d := (from + k) % 8
+			if inside(Point{cur.x + mooreNeighbors[d].x, cur.y + mooreNeighbors[d].y}) {
+				next = d
+				break
+			}
+		}
+		if next == -1 {
+			// A lone pixel has no boundary to walk.
+			return points
+		}
+		if cur == start {
+			if next == firstStep {
+				break
+			}
+			if firstStep == -1 {
+				firstStep = next
+			}
+		}
+
+		step := mooreNeighbors[next]
+		n := Point{cur.x + step.x, cur.y + step.y}
+		if stop != nil && stop(n) {
+			break
+		}
+
+		// The neighbour checked just before next is outside; it becomes
+		// the backtrack pixel, seen from the new position.
+		back := mooreNeighbors[(next+7)%8]
+		from = neighborIndex(back.x-step.x, back.y-step.y)
+		cur = n
+		points = append(points, cur)
+	}
+
+	return points
+}
+
+// neighborIndex returns the mooreNeighbors index of the offset dx, dy.
+func neighborIndex(dx, dy int) int {
+	for i, n := range mooreNeighbors {
+		if n.x == dx && n.y == dy {
+			return i
+		}
+	}
+	return 0
+}
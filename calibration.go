@@ -0,0 +1,157 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+// CalibrationGrid describes a material test: Cols squares stepping power
+// from PowerMin to PowerMax, by Rows stepping feed from FeedMin to FeedMax,
+// each labelled with its setting. Lengths are in the job's units.
+type CalibrationGrid struct {
+	Cols, Rows       int
+	PowerMin         int
+	PowerMax         int
+	FeedMin, FeedMax float64
+	CellSize         float64 // side of each test square
+	Gap              float64 // space between squares and labels
+}
+
+// DefaultCalibrationGrid returns the grid the CLI uses when no grid flags
+// are given.
+func DefaultCalibrationGrid() CalibrationGrid {
+	return CalibrationGrid{
+		Cols:     5,
+		Rows:     5,
+		PowerMin: 200,
+		PowerMax: 1000,
+		FeedMin:  500,
+		FeedMax:  2500,
+		CellSize: 10,
+		Gap:      3,
+	}
+}
+
+// calibrationPitch is the fill line spacing (mm) for test squares when the
+// config sets neither a line spacing nor a spot size.
+const calibrationPitch = 0.2
+
+// BuildCalibrationGrid returns the toolpath for grid, placed at the
+// config's offset. Squares are filled with horizontal lines at their own
+// power and feed; column labels below the grid give the power and row
+// labels to its left give the feed, both engraved at the config's power and
+// cutting feed.
+func BuildCalibrationGrid(grid CalibrationGrid, cfg Config) *Toolpath {
+	cfg = cfg.withDefaults()
+	tp := newToolpath(cfg)
+
+	pitch := calibrationPitch
+	switch {
+	case cfg.LineSpacing > 0:
+		pitch = cfg.LineSpacing
+	case cfg.SpotSize > 0:
+		pitch = cfg.SpotSize
+	}
+
+	labelHeight := math.Min(grid.CellSize/2, 5)
+	feedLabelWidth := 0.0
+	for r := 0; r < grid.Rows; r++ {
+		feedLabelWidth = math.Max(feedLabelWidth, textWidth(strconv.Itoa(int(math.Round(gridStep(grid.FeedMin, grid.FeedMax, r, grid.Rows)))), labelHeight))
+	}
+
+	left := cfg.OffsetX + feedLabelWidth + grid.Gap
+	bottom := cfg.OffsetY + labelHeight + grid.Gap
+	step := grid.CellSize + grid.Gap
+	label := newTool(cfg, 0)
+
+	for c := 0; c < grid.Cols; c++ {
+		power := int(math.Round(gridStep(float64(grid.PowerMin), float64(grid.PowerMax), c, grid.Cols)))
+		engraveText(strconv.Itoa(power), left+float64(c)*step, cfg.OffsetY, labelHeight, label, tp)
+	}
+
+	for r := 0; r < grid.Rows; r++ {
+		feed := gridStep(grid.FeedMin, grid.FeedMax, r, grid.Rows)
+		y := bottom + float64(r)*step
+		engraveText(strconv.Itoa(int(math.Round(feed))), cfg.OffsetX, y+(grid.CellSize-labelHeight)/2, labelHeight, label, tp)
+
+		for c := 0; c < grid.Cols; c++ {
+			t := newTool(cfg, 0)
+			t.power = int(math.Round(gridStep(float64(grid.PowerMin), float64(grid.PowerMax), c, grid.Cols)))
+			x := left + float64(c)*step
+
+			for i := 0; float64(i)*pitch <= grid.CellSize; i++ {
+				lineY := y + float64(i)*pitch
+				from, to := x, x+grid.CellSize
+				if i%2 == 1 {
+					from, to = to, from
+				}
+
+				tp.travel(from, lineY)
+				t.on(tp)
+				tp.add(Move{Type: MoveCut, X: to, Y: lineY, Feed: feed})
+				t.off(tp)
+			}
+		}
+	}
+
+	if cfg.Frame {
+		tp.Moves = frameMoves(tp.extents())
+	}
+
+	return tp
+}
+
+// gridStep returns the value for step i of n spread evenly from lo to hi.
+func gridStep(lo, hi float64, i, n int) float64 {
+	if n <= 1 {
+		return lo
+	}
+	return lo + (hi-lo)*float64(i)/float64(n-1)
+}
+
+// sevenSegments lists, for each digit, which of the segments a to g (top,
+// top right, bottom right, bottom, bottom left, top left, middle) are lit.
+var sevenSegments = [10]string{
+	"abcdef", "bc", "abdeg", "abcdg", "bcfg",
+	"acdfg", "acdefg", "abc", "abcdefg", "abcdfg",
+}
+
+// segmentEnds gives each segment's end points on a unit digit one wide and
+// two tall, with y up.
+var segmentEnds = map[byte][2]vec{
+	'a': {{0, 2}, {1, 2}},
+	'b': {{1, 2}, {1, 1}},
+	'c': {{1, 1}, {1, 0}},
+	'd': {{1, 0}, {0, 0}},
+	'e': {{0, 0}, {0, 1}},
+	'f': {{0, 1}, {0, 2}},
+	'g': {{0, 1}, {1, 1}},
+}
+
+// textWidth returns how wide engraveText draws digits at height.
+func textWidth(digits string, height float64) float64 {
+	if digits == "" {
+		return 0
+	}
+	return float64(len(digits))*height*0.8 - height*0.3
+}
+
+// engraveText strokes digits as seven-segment numerals with their bottom
+// left corner at x, y. Anything but a digit is left as a blank.
+func engraveText(digits string, x, y, height float64, t tool, tp *Toolpath) {
+	scale := height / 2
+	for i := 0; i < len(digits); i++ {
+		if digits[i] < '0' || digits[i] > '9' {
+			continue
+		}
+
+		left := x + float64(i)*height*0.8
+		for _, seg := range []byte(sevenSegments[digits[i]-'0']) {
+			ends := segmentEnds[seg]
+			tp.travel(left+ends[0].x*scale, y+ends[0].y*scale)
+			t.on(tp)
+			tp.cut(left+ends[1].x*scale, y+ends[1].y*scale)
+			t.off(tp)
+		}
+	}
+}
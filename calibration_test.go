@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestCalibrationGridSquares(t *testing.T) {
+	grid := DefaultCalibrationGrid()
+	grid.Cols, grid.Rows = 3, 4
+	grid.PowerMin, grid.PowerMax = 100, 900
+	grid.FeedMin, grid.FeedMax = 600, 1500
+	tp := BuildCalibrationGrid(grid, DefaultConfig())
+
+	type setting struct {
+		power int
+		feed  float64
+	}
+	boxes := map[setting]*extents{}
+	var power int
+	for _, m := range tp.Moves {
+		switch {
+		case m.Type == MoveLaserOn:
+			power = m.Power
+		case m.Type == MoveCut && m.Feed > 0:
+			// Only the test squares carry a feed of their own.
+			s := setting{power, m.Feed}
+			if boxes[s] == nil {
+				boxes[s] = &extents{}
+			}
+			boxes[s].add(m.X, m.Y)
+		}
+	}
+
+	if len(boxes) != grid.Cols*grid.Rows {
+		t.Fatalf("%d distinct power and feed settings, want %d", len(boxes), grid.Cols*grid.Rows)
+	}
+	for c, power := range []int{100, 500, 900} {
+		for r, feed := range []float64{600, 900, 1200, 1500} {
+			b := boxes[setting{power, feed}]
+			if b == nil {
+				t.Errorf("no square at power %d, feed %v (column %d, row %d)", power, feed, c, r)
+				continue
+			}
+			if w, h := b.maxX-b.minX, b.maxY-b.minY; w != grid.CellSize || h > grid.CellSize || h < grid.CellSize-calibrationPitch {
+				t.Errorf("square at power %d, feed %v is %v×%v, want %v square", power, feed, w, h, grid.CellSize)
+			}
+		}
+	}
+	for s, b := range boxes {
+		for o, other := range boxes {
+			if s != o && b.minX <= other.maxX && other.minX <= b.maxX && b.minY <= other.maxY && other.minY <= b.maxY {
+				t.Errorf("squares %v and %v overlap", s, o)
+			}
+		}
+	}
+}
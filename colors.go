@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ColorMatch is a GrayStrategy picking out one colour: black for colours
+// within Tolerance of Target, measured as the distance between their 8-bit
+// RGB values, and white for everything else. Run through it, an image keeps
+// only the parts drawn in that colour.
+type ColorMatch struct {
+	Target    color.RGBA
+	Tolerance float64
+}
+
+func (c ColorMatch) Gray(r, g, b, _ uint32) uint8 {
+	dr := float64(uint8(r>>8)) - float64(c.Target.R)
+	dg := float64(uint8(g>>8)) - float64(c.Target.G)
+	db := float64(uint8(b>>8)) - float64(c.Target.B)
+	if math.Sqrt(dr*dr+dg*dg+db*db) <= c.Tolerance {
+		return 0
+	}
+	return 255
+}
+
+// parseHexColor parses a colour written as in CSS, "#rrggbb" or "#rgb",
+// with or without the '#'.
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if len(hex) != 6 || err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: want #rrggbb", s)
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// colorLayers returns the images outlines and fills are taken from. They
+// are both the processed gray unless cfg gives a CutColor or EngraveColor:
+// then outlines are traced only around the parts of img in the cut colour
+// and fills cover only those in the engrave colour, each processed as gray
+// would be with a ColorMatch for its colour. A colour left empty contributes
+// nothing, and parts in neither colour are background.
+func colorLayers(img image.Image, gray *image.Gray, cfg Config) (outlines, fills *image.Gray, err error) {
+	if cfg.CutColor == "" && cfg.EngraveColor == "" {
+		return gray, gray, nil
+	}
+
+	layer := func(hex string) (*image.Gray, error) {
+		if hex == "" {
+			blank := image.NewGray(gray.Bounds())
+			for i := range blank.Pix {
+				blank.Pix[i] = 255
+			}
+			return blank, nil
+		}
+		target, err := parseHexColor(hex)
+		if err != nil {
+			return nil, err
+		}
+		layerCfg := cfg
+		layerCfg.Gray = ColorMatch{Target: target, Tolerance: cfg.ColorTolerance}
+		return ProcessImage(img, layerCfg), nil
+	}
+	if outlines, err = layer(cfg.CutColor); err != nil {
+		return nil, nil, err
+	}
+	if fills, err = layer(cfg.EngraveColor); err != nil {
+		return nil, nil, err
+	}
+	return outlines, fills, nil
+}
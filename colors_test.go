@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCutAndEngraveColors(t *testing.T) {
+	doc := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 60 30">` +
+		`<rect x="5" y="5" width="20" height="20" fill="#ff0000"/>` +
+		`<rect x="35" y="5" width="20" height="20" fill="#000000"/></svg>`
+	img, err := DecodeSVG(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Width, cfg.Height = 60, 30
+	cfg.CutColor, cfg.EngraveColor = "#ff0000", "#000000"
+	cfg.Comments = true
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The red square is on the left, the black one on the right.
+	var outlines, fills extents
+	filling := false
+	for _, m := range tp.Moves {
+		if m.Type == MoveComment {
+			filling = strings.HasPrefix(m.Text, "Fill region")
+		}
+		if m.Type != MoveCut {
+			continue
+		}
+		if filling {
+			fills.add(m.X, m.Y)
+		} else {
+			outlines.add(m.X, m.Y)
+		}
+	}
+	if !outlines.valid || outlines.minX < 4 || outlines.maxX > 26 {
+		t.Errorf("outlines span X %v to %v, want only the red square's 5 to 25", outlines.minX, outlines.maxX)
+	}
+	if !fills.valid || fills.minX < 34 || fills.maxX > 56 {
+		t.Errorf("fills span X %v to %v, want only the black square's 35 to 55", fills.minX, fills.maxX)
+	}
+}
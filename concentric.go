@@ -0,0 +1,172 @@
+package main
+
+import "math"
+
+// Fill modes. Zigzag hatches regions with parallel scan lines; concentric
+// follows the region's outline inwards in rings.
+const (
+	FillZigZag     = "zigzag"
+	FillConcentric = "concentric"
+)
+
+// fillConcentric fills a region with closed rings parallel to its outer
+// boundary, pitch apart in output units, from the boundary inwards until
+// the rings collapse. The boundary is straightened to within a pixel first
+// so the pixel staircase along slanted edges does not pile up as the rings
+// shrink.
+//
+// Rings are inset as polygons, which is exact for convex regions. Concave
+// regions are filled too, but their rings may cut across notches narrower
+// than the pitch, and holes are not avoided at all; the zigzag fill handles
+// both.
+func fillConcentric(points []Point, offsetX, offsetY, scaleX, scaleY, pitch float64, t tool, tp *Toolpath) {
+	boundary := traceRegionBoundary(points).points
+	if len(boundary) < 4 {
+		return
+	}
+
+	ring := simplifyRing(pointVecs(boundary[:len(boundary)-1]), 1)
+	for i, p := range ring {
+		ring[i] = vec{offsetX + p.x*scaleX, offsetY + p.y*scaleY}
+	}
+
+	// No ring can be deeper than half the region's larger side.
+	minX, minY, maxX, maxY := getBoundingBox(points)
+	limit := int(math.Max(float64(maxX-minX+1)*scaleX, float64(maxY-minY+1)*scaleY)/pitch/2) + 1
+
+	area := math.Abs(polygonArea(ring))
+	for n := 0; n < limit && len(ring) >= 3; n++ {
+		tp.travel(ring[0].x, ring[0].y)
+		t.on(tp)
+		for _, p := range ring[1:] {
+			tp.cut(p.x, p.y)
+		}
+		tp.cut(ring[0].x, ring[0].y)
+		t.off(tp)
+
+		next := insetPolygon(ring, pitch)
+		nextArea := math.Abs(polygonArea(next))
+		if nextArea >= area {
+			// The inset turned inside out rather than shrinking.
+			break
+		}
+		ring, area = next, nextArea
+	}
+}
+
+// insetPolygon moves every edge of the closed polygon poly inwards by d and
+// returns the polygon the moved edges enclose, or nil once it has vanished.
+// Edges that shrink to nothing on the way are dropped and their neighbours
+// extended to meet, as for a convex polygon.
+func insetPolygon(poly []vec, d float64) []vec {
+	// Work with the edges as lines: a point and a direction each.
+	type line struct{ p, dir vec }
+	sign := 1.0
+	if polygonArea(poly) < 0 {
+		sign = -1
+	}
+
+	lines := make([]line, 0, len(poly))
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		dx, dy := q.x-p.x, q.y-p.y
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*sign, dx/length*sign
+		lines = append(lines, line{vec{p.x + nx*d, p.y + ny*d}, vec{dx, dy}})
+	}
+
+	for len(lines) >= 3 {
+		verts := make([]vec, len(lines))
+		for i := range lines {
+			a, b := lines[(i+len(lines)-1)%len(lines)], lines[i]
+			verts[i] = intersectLines(a.p, a.dir, b.p, b.dir)
+		}
+
+		// An edge whose ends swapped over has been swallowed by its
+		// neighbours.
+		kept := lines[:0:0]
+		for i, l := range lines {
+			e := vec{verts[(i+1)%len(verts)].x - verts[i].x, verts[(i+1)%len(verts)].y - verts[i].y}
+			if e.x*l.dir.x+e.y*l.dir.y > 0 {
+				kept = append(kept, l)
+			}
+		}
+		if len(kept) == len(lines) {
+			return verts
+		}
+		lines = kept
+	}
+	return nil
+}
+
+// intersectLines returns where the line through p with direction r meets
+// the line through q with direction s. Parallel lines meet at q.
+func intersectLines(p, r, q, s vec) vec {
+	denom := r.x*s.y - r.y*s.x
+	if math.Abs(denom) < 1e-12 {
+		return q
+	}
+	t := ((q.x-p.x)*s.y - (q.y-p.y)*s.x) / denom
+	return vec{p.x + t*r.x, p.y + t*r.y}
+}
+
+// polygonArea returns the signed area of the closed polygon poly, positive
+// when its vertices run counter-clockwise with y up.
+func polygonArea(poly []vec) float64 {
+	area := 0.0
+	for i, p := range poly {
+		q := poly[(i+1)%len(poly)]
+		area += p.x*q.y - q.x*p.y
+	}
+	return area / 2
+}
+
+// simplifyRing drops the vertices of the closed polygon ring that lie within
+// tolerance of the line through their kept neighbours (Douglas-Peucker),
+// splitting the ring at the vertex farthest from its first.
+func simplifyRing(ring []vec, tolerance float64) []vec {
+	if len(ring) < 4 {
+		return ring
+	}
+
+	far, farDist := 0, 0.0
+	for i, p := range ring {
+		if d := math.Hypot(p.x-ring[0].x, p.y-ring[0].y); d > farDist {
+			far, farDist = i, d
+		}
+	}
+	if far == 0 {
+		return ring[:1]
+	}
+
+	closed := append(append([]vec{}, ring...), ring[0])
+	first := simplifyPolyline(closed[:far+1], tolerance)
+	second := simplifyPolyline(closed[far:], tolerance)
+	return append(first[:len(first)-1], second[:len(second)-1]...)
+}
+
+// simplifyPolyline is Douglas-Peucker on an open polyline, keeping both of
+// its ends.
+func simplifyPolyline(pts []vec, tolerance float64) []vec {
+	if len(pts) < 3 {
+		return append([]vec(nil), pts...)
+	}
+
+	first, last := pts[0], pts[len(pts)-1]
+	split, splitDist := 0, 0.0
+	for i := 1; i < len(pts)-1; i++ {
+		if d := distanceToLine(pts[i], first, last); d > splitDist {
+			split, splitDist = i, d
+		}
+	}
+	if splitDist <= tolerance {
+		return []vec{first, last}
+	}
+
+	left := simplifyPolyline(pts[:split+1], tolerance)
+	right := simplifyPolyline(pts[split:], tolerance)
+	return append(left[:len(left)-1], right...)
+}
@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestConcentricSquareRings(t *testing.T) {
+	var points []Point
+	for y := 10; y < 30; y++ {
+		for x := 10; x < 30; x++ {
+			points = append(points, Point{x, y})
+		}
+	}
+	tp := &Toolpath{}
+	fillConcentric(points, 0, 0, 1, 1, 3, newTool(DefaultConfig(), 0), tp)
+
+	// Each ring starts with a travel to its first corner.
+	var rings [][]Move
+	for _, m := range tp.Moves {
+		switch m.Type {
+		case MoveTravel:
+			rings = append(rings, []Move{m})
+		case MoveCut:
+			rings[len(rings)-1] = append(rings[len(rings)-1], m)
+		}
+	}
+	if len(rings) < 3 {
+		t.Fatalf("%d rings, want at least 3 in a 20px square at a 3px pitch", len(rings))
+	}
+
+	prev := extents{minX: -1e9, minY: -1e9, maxX: 1e9, maxY: 1e9}
+	for i, ring := range rings {
+		if len(ring) != 5 {
+			t.Errorf("ring %d has %d points, want a closed square's 5", i, len(ring))
+		}
+		var e extents
+		for _, m := range ring {
+			e.add(m.X, m.Y)
+		}
+		if e.maxX-e.minX != e.maxY-e.minY {
+			t.Errorf("ring %d is %v×%v, want a square", i, e.maxX-e.minX, e.maxY-e.minY)
+		}
+		if e.minX <= prev.minX || e.minY <= prev.minY || e.maxX >= prev.maxX || e.maxY >= prev.maxY {
+			t.Errorf("ring %d %+v is not inside the ring before it %+v", i, e, prev)
+		}
+		prev = e
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"os"
+)
+
+// Config holds every setting that shapes a conversion. Start from
+// DefaultConfig and override what you need; fields left at zero where zero
+// makes no sense (size, feeds, power) fall back to the defaults.
+//
+// Lengths are in Units (millimetres unless UnitsInch) and feeds in Units per
+// minute; scaling does not depend on the unit, only the output header and
+// coordinate precision do.
+type Config struct {
+	Units   string  `json:"units"`    // UnitsMM or UnitsInch
+	Width   float64 `json:"width"`    // target engraving width (mm)
+	Height  float64 `json:"height"`   // target engraving height (mm)
+	DPI     float64 `json:"dpi"`      // image resolution (pixels per inch); when positive it sets the size instead of Width and Height
+	Scale   float64 `json:"scale"`    // factor applied to the size from Width and Height or DPI; 0 means 1
+	OffsetX float64 `json:"offset_x"` // X offset (mm) of the engraving from the origin
+	OffsetY float64 `json:"offset_y"` // Y offset (mm) of the engraving from the origin
+
+	// Deprecated: Threshold has no effect; BackgroundThreshold decides what
+	// is engraved. It is still read from profiles so older ones load, with
+	// a warning from the CLI when set.
+	Threshold uint8 `json:"threshold,omitempty"`
+
+	// BackgroundThreshold is the gray level (0-255) at and above which a
+	// pixel is background: never traced, filled or kept by AutoCrop.
+	// Everything darker is part of the design. The outline and fill
+	// extractors go by this level alone, so light gray areas are engraved
+	// when it is raised above them.
+	BackgroundThreshold uint8 `json:"background_threshold"`
+
+	ReturnToOffset bool `json:"return_to_offset"` // park at the offset origin instead of the machine origin
+
+	// BedWidth and BedHeight, when positive, are the machine's travel
+	// from the origin (mm). A job moving beyond them fails with
+	// ErrOutsideBed, or with ClipToBed has those moves left out.
+	BedWidth  float64 `json:"bed_width"`
+	BedHeight float64 `json:"bed_height"`
+	ClipToBed bool    `json:"clip_to_bed"`
+
+	// TravelSafe, when positive, is the longest rapid (mm) the head may
+	// make with the tool engaged; longer ones switch the laser off, or
+	// retract the cutter, for the move. See travelGuard.
+	TravelSafe float64 `json:"travel_safe"`
+
+	Gray   GrayStrategy `json:"-"`      // color to gray conversion; nil uses LumaGray
+	Invert bool         `json:"invert"` // engrave the negative so light areas burn
+
+	// CutColor and EngraveColor, "#rrggbb", split the job by colour, as
+	// LightBurn layers do: outlines are cut only around the parts drawn
+	// within ColorTolerance of CutColor and only the parts near
+	// EngraveColor are filled; everything else is background. See
+	// colorLayers. Not with Invert, AutoCrop or Vector.
+	CutColor       string  `json:"cut_color"`
+	EngraveColor   string  `json:"engrave_color"`
+	ColorTolerance float64 `json:"color_tolerance"` // RGB distance (0-442) a pixel may stray from either colour
+
+	// CutColorPower and CutColorFeed (mm/min) are what the CutColor
+	// outlines are cut at, as a cut layer has settings of its own; 0 keeps
+	// Power or CutFeed. The fills stay at Power and CutFeed.
+	CutColorPower int     `json:"cut_color_power"`
+	CutColorFeed  float64 `json:"cut_color_feed"`
+
+	// AlphaThreshold is the opacity (0-255) below which a pixel is
+	// background, never engraved whatever its colour; see grayscaleImage.
+	// Fully transparent pixels always are.
+	AlphaThreshold uint8 `json:"alpha_threshold"`
+
+	// Noise filters, run on the gray image before the tone adjustments.
+	Denoise int     `json:"denoise"` // median filter kernel size in pixels (odd, 3 and up); 0 leaves the image alone
+	Blur    float64 `json:"blur"`    // Gaussian blur standard deviation in pixels; 0 leaves the image alone
+
+	// Tone adjustments, applied in this order after gray conversion and
+	// inversion; see adjustTone.
+	Brightness int     `json:"brightness"` // added to every gray level, -255..255
+	Contrast   float64 `json:"contrast"`   // gain around mid-gray: above 1 adds contrast, below 1 flattens
+	Gamma      float64 `json:"gamma"`      // tone curve exponent: above 1 lightens, below 1 darkens
+
+	// AutoThreshold splits the image into foreground and background after
+	// the tone adjustments at the level Otsu's method picks from its
+	// histogram instead of at BackgroundThreshold; see otsuLevel.
+	AutoThreshold bool `json:"auto_threshold"`
+
+	// Adaptive, AdaptiveMean or AdaptiveGaussian, turns the image black
+	// and white after the tone adjustments by comparing each pixel with the
+	// mean of the AdaptiveWindow pixels square around it less
+	// AdaptiveOffset, instead of leaving BackgroundThreshold to split it
+	// at one level; see adaptiveThreshold. Empty keeps the gray levels.
+	Adaptive       string  `json:"adaptive"`
+	AdaptiveWindow int     `json:"adaptive_window"` // odd window side in pixels; 0 means defaultAdaptiveWindow
+	AdaptiveOffset float64 `json:"adaptive_offset"` // levels below the local mean a pixel must be to count as foreground
+
+	// Supersample traces outlines on the gray image enlarged this many
+	// times, 2 to 4 being useful, for smoother slanted edges; fills are
+	// unaffected. 0 or 1 traces at the image's own resolution.
+	Supersample int `json:"supersample"`
+
+	EdgeDetect    string `json:"edge_detect"`    // outline edge test: EdgeNeighbor or EdgeSobel
+	EdgeThreshold int    `json:"edge_threshold"` // Sobel gradient magnitude (0-255) that counts as an edge
+
+	AutoCrop   bool `json:"auto_crop"`   // trim background around the design before scaling
+	CropMargin int  `json:"crop_margin"` // white border (pixels) kept around the design by AutoCrop
+
+	TravelFeed float64 `json:"travel_feed"` // rapid feed (mm/min)
+	CutFeed    float64 `json:"cut_feed"`    // cutting feed (mm/min)
+	Accel      float64 `json:"accel"`       // machine acceleration (mm/s²) for the time estimate; 0 ignores acceleration
+	Power      int     `json:"power"`       // laser power (S value) while burning
+	Dwell      int     `json:"dwell"`       // pause (ms) after each laser-on for the tube to settle; 0 for none
+	LaserMode  string  `json:"laser_mode"`  // LaserConstant (M3) or LaserDynamic (M4)
+	EndCommand string  `json:"end_command"` // program end: EndM2, EndM30 or EndNone
+
+	// PausePasses stops the program (M0) between one pass and the next,
+	// outline and fill passes alike, and PausePhases between the outlines
+	// and the fill, so the operator can refocus, check the work or swap
+	// the tool. The tool is disengaged before each stop.
+	PausePasses bool `json:"pause_passes"`
+	PausePhases bool `json:"pause_phases"`
+
+	// Precision, when set, is the number of decimal places coordinates are
+	// written with, 0 to 6, instead of 3 for millimetres and 4 for inches.
+	Precision *int `json:"precision,omitempty"`
+
+	// Relative writes the moves in incremental mode (G91), each as a step
+	// from the one before. Each axis' first move is written absolute.
+	Relative bool `json:"relative"`
+
+	// Home starts the program with a GRBL homing cycle ($H) and SetZero
+	// then makes the head's position the work origin (G10 L20 P1 X0 Y0),
+	// both ahead of the header. Machines without limit switches can't
+	// home, so both are off by default.
+	Home    bool `json:"home"`
+	SetZero bool `json:"set_zero"`
+
+	// Comments annotates the program with ';' comments: the job's source
+	// and settings at the top and a landmark before every outline and fill
+	// region. Source names the input for the first of them.
+	Comments bool   `json:"comments"`
+	Source   string `json:"-"`
+
+	// Raw leaves out the program start and end (units, feeds, homing, air
+	// assist, the header comment, templates and the park move) for pasting
+	// the moves into a larger program. The tool is still made safe at the
+	// end, and relative mode still returns to absolute.
+	Raw bool `json:"raw"`
+
+	// Header and Footer, when set, replace the built-in program start and
+	// end. See gcodeWriter.template for the substitution tokens.
+	Header string `json:"header"`
+	Footer string `json:"footer"`
+
+	AirAssist        bool   `json:"air_assist"`         // switch air assist on for the job and off (M9) at the end
+	AirAssistCommand string `json:"air_assist_command"` // coolant command driving the air: M8 (flood) or M7 (mist)
+
+	LineSpacing float64 `json:"line_spacing"` // fill scan line distance (mm); 0 derives it from SpotSize
+	SpotSize    float64 `json:"spot_size"`    // laser spot diameter (mm); 0 keeps the default pitch
+	Crosshatch  bool    `json:"crosshatch"`   // add a vertical fill pass on top of the horizontal one
+	FillMode    string  `json:"fill_mode"`    // FillZigZag scan lines or FillConcentric rings
+	FillAngle   float64 `json:"fill_angle"`   // zigzag scan line angle (degrees) from the X axis towards Y
+	MinSegment  float64 `json:"min_segment"`  // shortest fill line (mm) worth burning; 0 keeps the 3-pixel default
+	Overscan    float64 `json:"overscan"`     // distance (mm) run with the tool off before and after each fill line
+	Serpentine  bool    `json:"serpentine"`   // join the zigzag lines of a solid block with the tool on; not with Overscan
+
+	// Stipple engraves the image as a field of dots, denser where it is
+	// darker, instead of outlines and fills; see stipple. Dots are
+	// scattered one at most to each cell of a StippleSpacing grid and
+	// burnt for StippleDwell each. Where the dots fall is drawn from Seed,
+	// which every random choice of a job is, so the same seed always gives
+	// the same job.
+	Stipple        bool    `json:"stipple"`
+	StippleSpacing float64 `json:"stipple_spacing"` // grid pitch (mm); 0 takes the fill line spacing
+	StippleDwell   int     `json:"stipple_dwell"`   // burn time (ms) of each dot; 0 means defaultStippleDwell
+	Seed           int64   `json:"seed"`
+
+	PerimeterFirst bool `json:"perimeter_first"` // cut each fill region's boundary before its infill
+	FillInset      int  `json:"fill_inset"`      // pixels taken off the edge of each fill region so the outline isn't burnt twice; 0 fills to the edge
+	NoFill         bool `json:"no_fill"`         // trace outlines only, leaving fill regions alone
+
+	MinFillArea   float64 `json:"min_fill_area"`   // smallest region (mm²) worth filling; 0 keeps the 200-pixel default
+	MinPathPoints int     `json:"min_path_points"` // fewest traced points an outline needs to be cut; shorter ones are noise
+	MaxPaths      int     `json:"max_paths"`       // most outlines, or fill regions, a job may have before failing with ErrTooManyPaths; 0 for no limit
+
+	Passes     int     `json:"passes"`      // times the outlines are cut; 0 means once
+	PassDepth  float64 `json:"pass_depth"`  // Z step down (mm) for each outline pass after the first; 0 leaves Z alone
+	FillPasses int     `json:"fill_passes"` // times the fill is engraved; 0 means once
+
+	// PassAngleJitter turns the zigzag lines of each fill pass by an angle
+	// drawn from Seed, up to this many degrees either side of FillAngle, so
+	// passes burning over each other don't leave the brushed look of lines
+	// all run the same way. 0 runs every pass at FillAngle.
+	PassAngleJitter float64 `json:"pass_angle_jitter"`
+
+	// CNC replaces the laser commands with Z moves for a rotary tool:
+	// each path plunges to CutDepth at PlungeFeed and retracts to SafeZ.
+	CNC        bool    `json:"cnc"`
+	CutDepth   float64 `json:"cut_depth"`   // depth (mm) below Z0 to cut at
+	SafeZ      float64 `json:"safe_z"`      // height (mm) above Z0 to travel at
+	PlungeFeed float64 `json:"plunge_feed"` // Z feed (mm/min) while plunging
+
+	// LeadIn and LeadOut are the lengths (mm) at the start and end of each
+	// outline cut over which the feed ramps between LeadFeed and CutFeed,
+	// so the head does not linger and burn in where a cut starts and
+	// stops. LeadFeed 0 means twice CutFeed.
+	LeadIn   float64 `json:"lead_in"`
+	LeadOut  float64 `json:"lead_out"`
+	LeadFeed float64 `json:"lead_feed"`
+
+	// Tolerance is how far (pixels of the traced image) an outline or
+	// perimeter may stray from the traced pixels when simplified: a
+	// larger one leaves fewer, longer moves and loses fine detail. Anything
+	// under 1, 0 included, keeps every traced point; DefaultConfig uses 1.
+	Tolerance float64 `json:"tolerance"`
+
+	// MergeCollinear drops outline points lying within this many pixels
+	// of the straight cut past them, after simplification; 0 keeps them.
+	MergeCollinear float64 `json:"merge_collinear"`
+
+	// CloseLoops closes outlines whose end stops within CloseGap pixels of
+	// their start, by one more cut back to the start or, with SnapLoops, by
+	// moving the end onto it.
+	CloseLoops bool    `json:"close_loops"`
+	CloseGap   float64 `json:"close_gap"` // widest gap (pixels) CloseLoops bridges; 0 means defaultCloseGap
+	SnapLoops  bool    `json:"snap_loops"`
+
+	// Kerf is the width (mm) the beam cuts away. Closed outlines are
+	// offset by half of it, outer boundaries outwards and holes inwards,
+	// so parts keep their drawn size; see offsetForKerf. 0 cuts on the
+	// line.
+	Kerf float64 `json:"kerf"`
+
+	Smooth      int  `json:"smooth"`       // rounds of Chaikin corner cutting applied to each outline; 0 keeps the corners
+	Arcs        bool `json:"arcs"`         // fit G2/G3 arcs to curved runs of outline points
+	Vector      bool `json:"vector"`       // cut an SVG's own path geometry instead of tracing its pixels; not with AutoCrop
+	Optimize    bool `json:"optimize"`     // reorder outline paths and zigzag fills to minimise travel
+	FromContent bool `json:"from_content"` // register the content's corner, not the image's, to the offset
+	Centered    bool `json:"centered"`     // centre the content's bounding box on the offset, not its corner; not with FromContent
+	Frame       bool `json:"frame"`        // trace the bounding box with the laser off instead of engraving
+
+	// Progress, when set, is called as the conversion moves along.
+	Progress ProgressFunc `json:"-"`
+}
+
+// ProgressFunc receives the stage a conversion has reached and how much of
+// the whole job is done, from 0 to 1. Fractions never decrease.
+type ProgressFunc func(stage string, fraction float64)
+
+// Conversion stages reported to a ProgressFunc: outline tracing and fill
+// region extraction have finished, or moves are being emitted.
+const (
+	StageOutlines = "outlines"
+	StageFills    = "fills"
+	StageGCode    = "gcode"
+)
+
+// Share of the job done once each extraction stage finishes; emission
+// takes the rest.
+const (
+	progressOutlines = 0.4
+	progressFills    = 0.8
+)
+
+// Laser power modes. Dynamic mode (M4) scales power with the actual feed so
+// corners where the head slows down are not scorched.
+const (
+	LaserConstant = "constant"
+	LaserDynamic  = "dynamic"
+)
+
+// Program end commands. M2 ends the program; M30 also rewinds it on
+// controllers that care.
+const (
+	EndM2   = "M2"
+	EndM30  = "M30"
+	EndNone = "none"
+)
+
+// DefaultConfig returns the settings used by the CLI when no flags are given.
+func DefaultConfig() Config {
+	return Config{
+		Units:         UnitsMM,
+		Width:         100,
+		Height:        100,
+		Scale:         1,
+		Contrast:      1,
+		Gamma:         1,
+		EdgeDetect:    EdgeNeighbor,
+		FillMode:      FillZigZag,
+		EdgeThreshold: 64,
+		TravelFeed:    3000,
+		CutFeed:       1500,
+		Power:         1000,
+		LaserMode:     LaserConstant,
+		EndCommand:    EndM2,
+		Passes:        1,
+		FillPasses:    1,
+		CutDepth:      0.5,
+		SafeZ:         3,
+		PlungeFeed:    300,
+		MinPathPoints: 5,
+		CloseGap:      defaultCloseGap,
+		Tolerance:     1,
+		Seed:          1,
+
+		AdaptiveWindow: defaultAdaptiveWindow,
+		AdaptiveOffset: 10,
+
+		AirAssistCommand:    "M8",
+		BackgroundThreshold: backgroundLevel,
+		AlphaThreshold:      128,
+		ColorTolerance:      64,
+	}
+}
+
+// LoadConfig reads a machine profile: a JSON object of Config fields under
+// their json names. Fields the file leaves out keep their DefaultConfig
+// values; unknown fields are an error, so typos don't pass silently.
+func LoadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+
+	cfg := DefaultConfig()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// forImage returns cfg with Width and Height set to the size img will be
+// engraved at: its true size when a DPI is given, and either way times
+// Scale, so the header and everything else sized from them see the job as
+// it will be engraved. It is applied once, after withDefaults.
+func (cfg Config) forImage(img image.Image) Config {
+	if cfg.DPI > 0 {
+		b := img.Bounds()
+		cfg.Width = float64(b.Dx()) * cfg.unitsPerPixel()
+		cfg.Height = float64(b.Dy()) * cfg.unitsPerPixel()
+	}
+	cfg.Width *= cfg.Scale
+	cfg.Height *= cfg.Scale
+	return cfg
+}
+
+// unitsPerPixel is the size of a pixel at cfg's DPI, in cfg's units.
+func (cfg Config) unitsPerPixel() float64 {
+	if cfg.Units == UnitsInch {
+		return 1 / cfg.DPI
+	}
+	return 25.4 / cfg.DPI
+}
+
+// progress reports to Progress if one is set.
+func (cfg Config) progress(stage string, fraction float64) {
+	if cfg.Progress != nil {
+		cfg.Progress(stage, fraction)
+	}
+}
+
+// withDefaults fills fields whose zero value is unusable from DefaultConfig.
+func (cfg Config) withDefaults() Config {
+	def := DefaultConfig()
+	if cfg.Units == "" {
+		cfg.Units = def.Units
+	}
+	if cfg.Width == 0 {
+		cfg.Width = def.Width
+	}
+	if cfg.Height == 0 {
+		cfg.Height = def.Height
+	}
+	if cfg.Scale == 0 {
+		cfg.Scale = def.Scale
+	}
+	if cfg.BackgroundThreshold == 0 {
+		cfg.BackgroundThreshold = def.BackgroundThreshold
+	}
+	if cfg.Contrast == 0 {
+		cfg.Contrast = def.Contrast
+	}
+	if cfg.Gamma == 0 {
+		cfg.Gamma = def.Gamma
+	}
+	if cfg.CloseGap == 0 {
+		cfg.CloseGap = defaultCloseGap
+	}
+	if cfg.AdaptiveWindow == 0 {
+		cfg.AdaptiveWindow = defaultAdaptiveWindow
+	}
+	if cfg.FillMode == "" {
+		cfg.FillMode = def.FillMode
+	}
+	if cfg.EdgeDetect == "" {
+		cfg.EdgeDetect = def.EdgeDetect
+	}
+	if cfg.EdgeThreshold == 0 {
+		cfg.EdgeThreshold = def.EdgeThreshold
+	}
+	if cfg.TravelFeed == 0 {
+		cfg.TravelFeed = def.TravelFeed
+	}
+	if cfg.CutFeed == 0 {
+		cfg.CutFeed = def.CutFeed
+	}
+	if cfg.Power == 0 {
+		cfg.Power = def.Power
+	}
+	if cfg.LaserMode == "" {
+		cfg.LaserMode = def.LaserMode
+	}
+	if cfg.EndCommand == "" {
+		cfg.EndCommand = def.EndCommand
+	}
+	if cfg.Passes == 0 {
+		cfg.Passes = def.Passes
+	}
+	if cfg.FillPasses == 0 {
+		cfg.FillPasses = def.FillPasses
+	}
+	if cfg.CutDepth == 0 {
+		cfg.CutDepth = def.CutDepth
+	}
+	if cfg.SafeZ == 0 {
+		cfg.SafeZ = def.SafeZ
+	}
+	if cfg.PlungeFeed == 0 {
+		cfg.PlungeFeed = def.PlungeFeed
+	}
+	if cfg.MinPathPoints == 0 {
+		cfg.MinPathPoints = def.MinPathPoints
+	}
+	if cfg.AirAssistCommand == "" {
+		cfg.AirAssistCommand = def.AirAssistCommand
+	}
+	return cfg
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDefaultConfigRoundTrip(t *testing.T) {
+	data, err := json.Marshal(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "profile.json")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, DefaultConfig()) {
+		t.Errorf("defaults changed through a profile:\n got %+v\nwant %+v", cfg, DefaultConfig())
+	}
+}
+
+func TestWithDefaultsFillsZeroConfig(t *testing.T) {
+	cfg, def := Config{}.withDefaults(), DefaultConfig()
+	if cfg.Width != def.Width || cfg.Height != def.Height || cfg.CutFeed != def.CutFeed || cfg.Power != def.Power {
+		t.Errorf("zero Config filled in as %+v", cfg)
+	}
+	if cfg.Units != def.Units || cfg.FillMode != def.FillMode || cfg.Passes != def.Passes {
+		t.Errorf("zero Config filled in as %+v", cfg)
+	}
+	if kept := (Config{Width: 40, Power: 300}).withDefaults(); kept.Width != 40 || kept.Power != 300 {
+		t.Errorf("withDefaults replaced fields that were set: %+v", kept)
+	}
+}
+
+func TestForImageDPI(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DPI = 300
+	sized := cfg.forImage(image.NewGray(image.Rect(0, 0, 300, 150)))
+	if math.Abs(sized.Width-25.4) > 1e-9 || math.Abs(sized.Height-12.7) > 1e-9 {
+		t.Errorf("300×150 pixels at 300 DPI sized %v×%v mm, want 25.4×12.7", sized.Width, sized.Height)
+	}
+}
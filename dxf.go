@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// WriteDXF converts img and writes its outline paths to w as a minimal DXF
+// drawing of LWPOLYLINE entities, placed exactly as the G-code would cut
+// them. Fill regions are left out.
+func WriteDXF(w io.Writer, img image.Image, cfg Config) error {
+	cfg.NoFill = true
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		return err
+	}
+	return tp.WriteDXF(w)
+}
+
+// WriteDXF writes every cut run of the toolpath as one polyline: a travel
+// starts a new one and cuts and arcs extend it, arcs as bulged segments.
+// Runs that end where they start are closed polylines.
+func (tp *Toolpath) WriteDXF(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	// $INSUNITS 4 is millimetres, 1 inches.
+	insunits := 4
+	if tp.Units == UnitsInch {
+		insunits = 1
+	}
+	fmt.Fprintf(bw, "0\nSECTION\n2\nHEADER\n9\n$ACADVER\n1\nAC1015\n9\n$INSUNITS\n70\n%d\n0\nENDSEC\n", insunits)
+	bw.WriteString("0\nSECTION\n2\nENTITIES\n")
+
+	var run []dxfVertex
+	for _, m := range tp.Moves {
+		switch m.Type {
+		case MoveTravel, MoveOverscan:
+			writePolyline(bw, run)
+			run = []dxfVertex{{x: m.X, y: m.Y}}
+		case MoveCut, MoveArcCW, MoveArcCCW:
+			if len(run) == 0 {
+				continue
+			}
+			if m.Type != MoveCut {
+				prev := &run[len(run)-1]
+				prev.bulge = arcBulge(*prev, m)
+			}
+			run = append(run, dxfVertex{x: m.X, y: m.Y})
+		}
+	}
+	writePolyline(bw, run)
+
+	bw.WriteString("0\nENDSEC\n0\nEOF\n")
+	return bw.Flush()
+}
+
+// dxfVertex is a polyline vertex; bulge curves the segment that leaves it.
+type dxfVertex struct {
+	x, y, bulge float64
+}
+
+// arcBulge returns the DXF bulge for the arc m starting at from: the
+// tangent of a quarter of its sweep, negative for clockwise.
+func arcBulge(from dxfVertex, m Move) float64 {
+	cx, cy := from.x+m.I, from.y+m.J
+	start := math.Atan2(from.y-cy, from.x-cx)
+	end := math.Atan2(m.Y-cy, m.X-cx)
+
+	sweep := end - start
+	if m.Type == MoveArcCCW {
+		if sweep <= 0 {
+			sweep += 2 * math.Pi
+		}
+	} else if sweep >= 0 {
+		sweep -= 2 * math.Pi
+	}
+	return math.Tan(sweep / 4)
+}
+
+// writePolyline writes run as an LWPOLYLINE entity, closing it when its
+// last vertex repeats the first. Runs of fewer than two vertices draw
+// nothing and are skipped.
+func writePolyline(w *bufio.Writer, run []dxfVertex) {
+	if len(run) < 2 {
+		return
+	}
+
+	closed := 0
+	first, last := run[0], run[len(run)-1]
+	if len(run) > 2 && first.x == last.x && first.y == last.y {
+		run = run[:len(run)-1]
+		closed = 1
+	}
+
+	fmt.Fprintf(w, "0\nLWPOLYLINE\n100\nAcDbEntity\n8\n0\n100\nAcDbPolyline\n90\n%d\n70\n%d\n", len(run), closed)
+	for _, v := range run {
+		fmt.Fprintf(w, "10\n%g\n20\n%g\n", v.x, v.y)
+		if v.bulge != 0 {
+			fmt.Fprintf(w, "42\n%g\n", v.bulge)
+		}
+	}
+}
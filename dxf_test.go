@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// dxfPairs splits a DXF document into its group code and value pairs.
+func dxfPairs(t *testing.T, doc string) [][2]string {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(doc, "\n"), "\n")
+	if len(lines)%2 != 0 {
+		t.Fatalf("DXF has %d lines, want code and value pairs", len(lines))
+	}
+	pairs := make([][2]string, len(lines)/2)
+	for i := range pairs {
+		pairs[i] = [2]string{strings.TrimSpace(lines[2*i]), lines[2*i+1]}
+	}
+	return pairs
+}
+
+func TestWriteDXF(t *testing.T) {
+	img := squares(100, 100, 20, [2]int{5, 5}, [2]int{60, 10}, [2]int{30, 60})
+	cfg := DefaultConfig()
+	traced := dropShortPaths(extractOutlinePaths(ProcessImage(img, cfg), cfg.BackgroundThreshold), cfg.MinPathPoints)
+
+	var buf bytes.Buffer
+	if err := WriteDXF(&buf, img, cfg); err != nil {
+		t.Fatal(err)
+	}
+	pairs := dxfPairs(t, buf.String())
+
+	var units string
+	polylines, closed := 0, 0
+	for i, p := range pairs {
+		switch {
+		case p == [2]string{"9", "$INSUNITS"} && i+1 < len(pairs):
+			units = pairs[i+1][1]
+		case p == [2]string{"0", "LWPOLYLINE"}:
+			polylines++
+		case p == [2]string{"70", "1"}:
+			closed++
+		}
+	}
+	if units != "4" {
+		t.Errorf("$INSUNITS is %q, want 4 for millimetres", units)
+	}
+	if polylines != len(traced) || polylines != 3 {
+		t.Errorf("%d polylines for %d traced paths, want one each for the 3 squares", polylines, len(traced))
+	}
+	if closed != polylines {
+		t.Errorf("%d of %d polylines closed, want all", closed, polylines)
+	}
+	if pairs[len(pairs)-1] != [2]string{"0", "EOF"} {
+		t.Errorf("DXF ends with %v, want EOF", pairs[len(pairs)-1])
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// Edge detection methods for outline tracing. EdgeNeighbor outlines the
+// foreground against the background; EdgeSobel follows steep tonal changes
+// anywhere in the image, including inside the foreground.
+const (
+	EdgeNeighbor = "neighbor"
+	EdgeSobel    = "sobel"
+)
+
+// sobelEdges returns a width*height map of the pixels on a steep tonal
+// edge: those whose Sobel gradient magnitude reaches threshold and is a
+// local maximum across the edge, so each edge is one pixel wide. The
+// magnitude is scaled so a hard black to white step measures 255.
+func sobelEdges(img *image.Gray, threshold int) []bool {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// at clamps to the border so the image edge itself isn't a gradient.
+	at := func(x, y int) float64 {
+		x = min(max(x, 0), w-1)
+		y = min(max(y, 0), h-1)
+		return float64(img.Pix[y*img.Stride+x])
+	}
+
+	mag := make([]float64, w*h)
+	dir := make([]uint8, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) -
+				at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+			gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) -
+				at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+
+			mag[y*w+x] = math.Hypot(gx, gy) / 4
+			dir[y*w+x] = gradientSector(gx, gy)
+		}
+	}
+
+	// Neighbour offsets across the edge for each gradient sector:
+	// horizontal, one diagonal, vertical, the other diagonal.
+	across := [4]Point{{1, 0}, {1, 1}, {0, 1}, {-1, 1}}
+
+	edges := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			m := mag[y*w+x]
+			if m < float64(threshold) {
+				continue
+			}
+
+			d := across[dir[y*w+x]]
+			ax, ay, bx, by := x+d.x, y+d.y, x-d.x, y-d.y
+			if ax >= 0 && ay >= 0 && ax < w && ay < h && mag[ay*w+ax] > m {
+				continue
+			}
+			// Ties go to the first pixel met so a plateau stays thin.
+			if bx >= 0 && by >= 0 && bx < w && by < h && mag[by*w+bx] >= m {
+				continue
+			}
+			edges[y*w+x] = true
+		}
+	}
+
+	return edges
+}
+
+// gradientSector quantizes the gradient direction gx, gy to one of four
+// sectors 45 degrees apart, as indexes into the across table.
+func gradientSector(gx, gy float64) uint8 {
+	angle := math.Atan2(gy, gx) * 180 / math.Pi
+	if angle < 0 {
+		angle += 180
+	}
+
+	switch {
+	case angle < 22.5 || angle >= 157.5:
+		return 0
+	case angle < 67.5:
+		return 1
+	case angle < 112.5:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// extractSobelPaths traces the one-pixel-wide lines of the Sobel edge map
+// of img into paths. A trace ends where it would double back over itself,
+// at the end of an open line or at a junction; whatever is left of a
+// branching line is picked up by later traces.
+func extractSobelPaths(img *image.Gray, threshold int) []Path {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	edges := sobelEdges(img, threshold)
+	visited := newBitset(w * h)
+	traceOf := make([]int, w*h)
+
+	inside := func(p Point) bool {
+		return p.x >= 0 && p.y >= 0 && p.x < w && p.y < h && edges[p.y*w+p.x]
+	}
+
+	var paths []Path
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !edges[y*w+x] || visited.get(y*w+x) {
+				continue
+			}
+
+			start := Point{x, y}
+			id := len(paths) + 1
+			traceOf[y*w+x] = id
+			stop := func(p Point) bool {
+				i := p.y*w + p.x
+				if visited.get(i) || (traceOf[i] == id && p != start) {
+					return true
+				}
+				traceOf[i] = id
+				return false
+			}
+
+			from := 0
+			for d, n := range mooreNeighbors {
+				if !inside(Point{x + n.x, y + n.y}) {
+					from = d
+					break
+				}
+			}
+
+			points := mooreTrace(start, from, inside, stop, 4*w*h)
+			for _, p := range points {
+				visited.set(p.y*w + p.x)
+			}
+			paths = append(paths, Path{points: points})
+		}
+	}
+
+	return paths
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestSobelEdgesOnlyAtStep(t *testing.T) {
+	// A gentle ramp from black over the left 60 columns, then a jump to
+	// white.
+	const w, h, step = 100, 20, 60
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(2 * x)
+			if x >= step {
+				v = 255
+			}
+			img.Pix[y*img.Stride+x] = v
+		}
+	}
+
+	edges := sobelEdges(img, 64)
+	for y := 0; y < h; y++ {
+		var xs []int
+		for x := 0; x < w; x++ {
+			if edges[y*w+x] {
+				xs = append(xs, x)
+			}
+		}
+		if len(xs) != 1 || xs[0] < step-1 || xs[0] > step {
+			t.Errorf("row %d has edges at %v, want one at the step by x=%d", y, xs, step)
+		}
+	}
+}
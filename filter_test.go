@@ -0,0 +1,23 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestMedianFilterRemovesSaltAndPepper(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 40, 40))
+	for i := range img.Pix {
+		img.Pix[i] = 128
+	}
+	for i := 0; i < len(img.Pix); i += 23 {
+		img.Pix[i] = uint8(255 * (i / 23 % 2))
+	}
+
+	out := medianFilter(img, 3)
+	for i, v := range out.Pix {
+		if v != 128 {
+			t.Fatalf("pixel %d is %d after the median filter, want the flat 128", i, v)
+		}
+	}
+}
@@ -1,58 +1,784 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"image"
+	"io"
 	"math"
+	"math/rand"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
-func ConvertToGCode(img image.Image, targetWidth, targetHeight, offset float64, threshold uint8) (string, error) {
-	bounds := img.Bounds()
+func ConvertToGCode(img image.Image, cfg Config) (string, error) {
+	var buf bytes.Buffer
+	if err := WriteGCode(&buf, img, cfg); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// WriteGCode converts img and writes the program to w as paths and fill
+// lines are generated, so the whole program never has to be held in memory.
+// Modes that need the complete job before the first move (frame,
+// origin-offset-from-content and centered) collect the toolpath first.
+func WriteGCode(w io.Writer, img image.Image, cfg Config) error {
+	_, err := WriteGCodeStats(w, img, cfg)
+	return err
+}
+
+// WriteGCodeStats is WriteGCode that also measures the job it writes.
+func WriteGCodeStats(w io.Writer, img image.Image, cfg Config) (JobStats, error) {
+	cfg = cfg.withDefaults()
+	if cfg.Frame || cfg.FromContent || cfg.Centered {
+		tp, err := BuildToolpath(img, cfg)
+		if err != nil {
+			return JobStats{}, err
+		}
+		return tp.Stats(), tp.WriteGCode(w, cfg.forImage(img))
+	}
+	cfg = cfg.forImage(img)
+
+	bw := bufio.NewWriter(w)
+	tp := newToolpath(cfg)
+	gw := newGCodeWriter(bw, tp, cfg)
+	stats := newStatsCounter(tp)
+	bed := newBedGuard(cfg)
+	travel := newTravelGuard(cfg)
+	emit := func(m Move) {
+		stats.add(m)
+		gw.move(m)
+	}
+	tp.sink = func(m Move) {
+		bed.add(m, func(m Move) { travel.add(m, emit) })
+	}
+
+	gw.header()
+	if err := generateToolpath(img, cfg, tp); err != nil {
+		return JobStats{}, err
+	}
+	if bed != nil && bed.err != nil {
+		return JobStats{}, bed.err
+	}
+
+	gw.footer()
+	return stats.result(), bw.Flush()
+}
+
+// BuildToolpath traces and fills img and returns the resulting moves
+// without committing to any output format.
+func BuildToolpath(img image.Image, cfg Config) (*Toolpath, error) {
+	cfg = cfg.withDefaults().forImage(img)
+
+	tp := newToolpath(cfg)
+	if err := generateToolpath(img, cfg, tp); err != nil {
+		return nil, err
+	}
+
+	if cfg.FromContent {
+		// Register the content's own corner, not the image's, to the
+		// origin plus offset so surrounding whitespace is ignored.
+		if e := tp.extents(); e.valid {
+			tp.translate(cfg.OffsetX-e.minX, cfg.OffsetY-e.minY)
+		}
+	}
+	if cfg.Centered {
+		// The offset is where the middle of the content goes, the
+		// work origin unless one is given.
+		if e := tp.extents(); e.valid {
+			tp.translate(cfg.OffsetX-(e.minX+e.maxX)/2, cfg.OffsetY-(e.minY+e.maxY)/2)
+		}
+	}
+
+	if cfg.Frame {
+		tp.Moves = frameMoves(tp.extents())
+	}
+
+	moves, err := newBedGuard(cfg).filterMoves(tp.Moves)
+	if err != nil {
+		return nil, err
+	}
+	tp.Moves = newTravelGuard(cfg).filterMoves(moves)
+
+	return tp, nil
+}
+
+// newToolpath returns an empty toolpath carrying the feeds and final park
+// position from cfg.
+func newToolpath(cfg Config) *Toolpath {
+	tp := &Toolpath{Units: cfg.Units, TravelFeed: cfg.TravelFeed, CutFeed: cfg.CutFeed, Accel: cfg.Accel}
+	if cfg.ReturnToOffset {
+		tp.ReturnX, tp.ReturnY = cfg.OffsetX, cfg.OffsetY
+	}
+	return tp
+}
+
+// ErrNothingToEngrave is returned for an image with no outlines or fill
+// regions to engrave, such as an all-white one or one whose every path is
+// under the minimum sizes, instead of a program that does nothing.
+var ErrNothingToEngrave = errors.New("nothing to engrave: no outlines or fill regions darker than the background threshold and over the minimum sizes")
+
+// ErrTooManyPaths is returned, wrapped with the count, for an image tracing
+// to more outlines or fill regions than Config.MaxPaths allows: usually scan
+// noise, which would make a program too big to send.
+var ErrTooManyPaths = errors.New("too many paths; denoise or blur the image, or lower the background threshold")
+
+// generateToolpath traces and fills img, adding every move to tp in the
+// order it should run.
+func generateToolpath(img image.Image, cfg Config, tp *Toolpath) error {
+	gray := ProcessImage(img, cfg)
+
+	bounds := gray.Bounds()
 	imgWidth := bounds.Dx()
 	imgHeight := bounds.Dy()
-	scaleX := targetWidth / float64(imgWidth)
-	scaleY := targetHeight / float64(imgHeight)
+	if imgWidth == 0 || imgHeight == 0 {
+		return errors.New("image is empty")
+	}
+	scaleX := cfg.Width / float64(imgWidth)
+	scaleY := cfg.Height / float64(imgHeight)
+	if cfg.DPI > 0 {
+		// Pixels keep their true size however much AutoCrop took away.
+		scaleX, scaleY = cfg.unitsPerPixel()*cfg.Scale, cfg.unitsPerPixel()*cfg.Scale
+	}
+	offsetX, offsetY := cfg.OffsetX, cfg.OffsetY
 
-	var sb strings.Builder
-	sb.WriteString("G21\nG90\nM5\nG0 F3000\nG1 F1500\n")
+	// Every random choice of the job is drawn from this one source, so
+	// the job repeats exactly from the same seed.
+	rng := rand.New(rand.NewSource(cfg.Seed))
 
-	outlines := extractOutlinePaths(img, threshold)
-	fillAreas := extractFillRegions(img, threshold)
+	if cfg.Stipple {
+		spacing := cfg.StippleSpacing
+		if spacing == 0 {
+			spacing = cfg.LineSpacing
+		}
+		dwell := cfg.StippleDwell
+		if dwell == 0 {
+			dwell = defaultStippleDwell
+		}
+		// A plunged cutter marks the spot without waiting.
+		seconds := float64(dwell) / 1000
+		if cfg.CNC {
+			seconds = 0
+		}
+		pitchX, pitchY := fillPitch(spacing, cfg.SpotSize, scaleX), fillPitch(spacing, cfg.SpotSize, scaleY)
+		if stipple(gray, cfg.BackgroundThreshold, offsetX, offsetY, scaleX, scaleY, pitchX, pitchY, seconds, rng, newTool(cfg, 0), tp) == 0 {
+			return ErrNothingToEngrave
+		}
+		cfg.progress(StageGCode, 1)
+		return nil
+	}
 
-	for _, path := range outlines {
-		if len(path.points) < 5 {
-			continue
+	outlineGray, fillGray, err := colorLayers(img, gray, cfg)
+	if err != nil {
+		return err
+	}
+
+	// contours are the outlines to cut, in pixels.
+	var contours [][]vec
+	// Vector outlines are in the document's own coordinates, which
+	// cropping would no longer match.
+	if src, ok := img.(*svgImage); ok && cfg.Vector && !cfg.AutoCrop {
+		contours = src.contours
+		if cfg.Optimize {
+			contours = orderContoursNearest(contours, scaleX, scaleY)
+		}
+	} else {
+		// Supersampled outlines are traced on an enlarged copy, which
+		// smooths the pixel staircase along slanted edges, and scaled
+		// back down to pixels of gray once simplified.
+		n := max(cfg.Supersample, 1)
+
+		var outlines []Path
+		switch {
+		case cfg.EdgeDetect == EdgeSobel:
+			// Enlarging spreads each step over n pixels, weakening the
+			// gradient by as much.
+			outlines = extractSobelPaths(upscale(outlineGray, n), cfg.EdgeThreshold/n)
+		case n > 1:
+			// Enlarge the foreground mask rather than the gray levels and
+			// split it halfway, so the outline runs along the pixel edges
+			// however light the background threshold is. Blurring it over
+			// a source pixel rounds the steps off a staircase.
+			mask := upscale(foregroundMask(outlineGray, cfg.BackgroundThreshold), n)
+			outlines = extractOutlinePaths(gaussianBlur(mask, float64(n)), 128)
+		default:
+			outlines = extractOutlinePaths(outlineGray, cfg.BackgroundThreshold)
+		}
+		outlines = dropShortPaths(outlines, cfg.MinPathPoints*n)
+		if cfg.Optimize {
+			outlines = orderPathsNearest(outlines, scaleX/float64(n), scaleY/float64(n))
+		}
+		for _, path := range outlines {
+			contour := pointVecs(simplifyPath(path.points, cfg.Tolerance))
+			if n > 1 {
+				for i, p := range contour {
+					// Enlarged pixel centres sit half a small pixel in
+					// from the corners of the pixels they subdivide.
+					contour[i] = vec{(p.x+0.5)/float64(n) - 0.5, (p.y+0.5)/float64(n) - 0.5}
+				}
+			}
+			contours = append(contours, contour)
+		}
+	}
+	if cfg.MergeCollinear > 0 {
+		merged := make([][]vec, len(contours))
+		for i, c := range contours {
+			merged[i] = mergeCollinear(c, cfg.MergeCollinear)
+		}
+		contours = merged
+	}
+	if cfg.CloseLoops {
+		closed := make([][]vec, len(contours))
+		for i, c := range contours {
+			closed[i] = closeLoop(c, cfg.CloseGap, cfg.SnapLoops)
+		}
+		contours = closed
+	}
+	if cfg.Smooth > 0 {
+		smoothed := make([][]vec, len(contours))
+		for i, c := range contours {
+			smoothed[i] = smoothChaikin(c, cfg.Smooth)
+		}
+		contours = smoothed
+	}
+	if cfg.Kerf > 0 {
+		contours = offsetForKerf(contours, cfg.Kerf, cfg.CloseGap, scaleX, scaleY)
+	}
+	if cfg.MaxPaths > 0 && len(contours) > cfg.MaxPaths {
+		return fmt.Errorf("%w: %d outlines, the limit is %d", ErrTooManyPaths, len(contours), cfg.MaxPaths)
+	}
+	cfg.progress(StageOutlines, progressOutlines)
+
+	var fillAreas []Path
+	if !cfg.NoFill {
+		fillAreas = extractFillRegions(fillGray, cfg.BackgroundThreshold)
+	}
+	minFill := minFillPixels(cfg.MinFillArea, scaleX, scaleY)
+	filled := 0
+	for _, region := range fillAreas {
+		if len(region.points) >= minFill {
+			filled++
+		}
+	}
+	// Judged on what survives the minimum sizes, which can leave nothing
+	// of an image that does have dark pixels.
+	if len(contours) == 0 && filled == 0 {
+		return ErrNothingToEngrave
+	}
+	if cfg.MaxPaths > 0 && filled > cfg.MaxPaths {
+		return fmt.Errorf("%w: %d fill regions, the limit is %d", ErrTooManyPaths, filled, cfg.MaxPaths)
+	}
+	cfg.progress(StageFills, progressFills)
+	lineSpacing := fillPitch(cfg.LineSpacing, cfg.SpotSize, scaleY)
+	crossSpacing := 0
+	if cfg.Crosshatch {
+		crossSpacing = fillPitch(cfg.LineSpacing, cfg.SpotSize, scaleX)
+	}
+
+	corners := make([]fillCorner, len(fillAreas))
+	if cfg.Optimize && cfg.FillMode == FillZigZag {
+		// Fills pick up where the outlines leave the head.
+		var from vec
+		if len(contours) > 0 {
+			last := contours[len(contours)-1]
+			from = last[len(last)-1]
 		}
+		fillAreas, corners = orderFillsNearest(fillAreas, minFill, from, lineSpacing, cfg.FillAngle, scaleX, scaleY)
+	}
+
+	// Traced points sit on the pixel grid, so they stray up to about a
+	// pixel from the curve they sample; arcs are fitted to that accuracy.
+	arcTolerance := 0.0
+	if cfg.Arcs {
+		arcTolerance = math.Max(scaleX, scaleY)
+	}
+
+	ramp := newFeedRamp(cfg)
 
-		sb.WriteString("M5\n")
-		firstPoint := true
-		simplifiedPath := simplifyPath(path.points, 1.0)
+	// Outlines traced from the cut colour are cut at its settings.
+	cutCfg := cfg
+	if cfg.CutColor != "" {
+		if cfg.CutColorPower > 0 {
+			cutCfg.Power = cfg.CutColorPower
+		}
+		if cfg.CutColorFeed > 0 {
+			cutCfg.CutFeed = cfg.CutColorFeed
+		}
+	}
+	cutRamp := newFeedRamp(cutCfg)
 
-		for _, point := range simplifiedPath {
-			x := offset + float64(point.x)*scaleX
-			y := offset + float64(point.y)*scaleY
+	// Emission progress counts outline paths and fill regions alike.
+	emitted, toEmit := 0, cfg.Passes*len(contours)+cfg.FillPasses*len(fillAreas)
+	step := func() {
+		emitted++
+		cfg.progress(StageGCode, progressFills+(1-progressFills)*float64(emitted)/float64(toEmit))
+	}
 
-			if firstPoint {
-				sb.WriteString(fmt.Sprintf("G0 X%.3f Y%.3f\nM3 S1000\n", x, y))
-				firstPoint = false
-			} else {
-				sb.WriteString(fmt.Sprintf("G1 X%.3f Y%.3f\n", x, y))
+	for pass := 0; pass < cfg.Passes; pass++ {
+		t := newTool(cutCfg, pass)
+		if pass > 0 && cfg.PausePasses && len(contours) > 0 {
+			newTool(cutCfg, pass-1).off(tp)
+			tp.pause()
+		}
+		// Each laser pass after the first steps Z down by PassDepth so the
+		// outline is cut deeper rather than just burnt again. A CNC tool
+		// plunges to its pass depth on every engage instead.
+		if !cfg.CNC && cfg.PassDepth > 0 && len(contours) > 0 {
+			tp.laserOff()
+			tp.plunge(float64(-pass)*cfg.PassDepth, 0)
+		}
+
+		for i, contour := range contours {
+			t.off(tp)
+			if cfg.Comments {
+				tp.comment("Path %d (%d points)", i+1, len(contour))
 			}
+			cutPath(contour, offsetX, offsetY, scaleX, scaleY, arcTolerance, cutRamp, t, tp)
+			step()
 		}
 	}
+	if cfg.Passes > 0 && len(contours) > 0 {
+		// The last outline leaves the tool engaged; it must not travel to
+		// the fills, or back home, burning or dragging through the work.
+		newTool(cutCfg, cfg.Passes-1).off(tp)
+	}
+	if !cfg.CNC && cfg.PassDepth > 0 && len(contours) > 0 {
+		tp.retract(0)
+	}
 
-	for _, region := range fillAreas {
-		if len(region.points) < 200 {
-			continue
+	filling := cfg.FillPasses > 0 && slices.ContainsFunc(fillAreas, func(region Path) bool {
+		return len(region.points) >= minFill
+	})
+	if cfg.PausePhases && cfg.Passes > 0 && len(contours) > 0 && filling {
+		tp.pause()
+	}
+
+	// Inset fills leave alone the band of edge pixels the outlines and
+	// perimeters already burn.
+	infill := make([][]Point, len(fillAreas))
+	for i, region := range fillAreas {
+		infill[i] = region.points
+		if cfg.FillInset > 0 && len(region.points) >= minFill {
+			infill[i] = erodeRegion(region.points, cfg.FillInset)
+		}
+	}
+
+	for pass := 0; pass < cfg.FillPasses; pass++ {
+		t := newTool(cfg, 0)
+		angle := cfg.FillAngle
+		if cfg.PassAngleJitter > 0 {
+			angle += (2*rng.Float64() - 1) * cfg.PassAngleJitter
+		}
+		if pass > 0 && cfg.PausePasses && filling {
+			// Every fill leaves the tool disengaged.
+			tp.pause()
 		}
+		for i, region := range fillAreas {
+			step()
+			if len(region.points) < minFill {
+				continue
+			}
+			if cfg.Comments {
+				minX, minY, maxX, maxY := getBoundingBox(region.points)
+				tp.comment("Fill region %d bbox=%.3f,%.3f,%.3f,%.3f", i+1,
+					offsetX+float64(minX)*scaleX, offsetY+float64(minY)*scaleY,
+					offsetX+float64(maxX)*scaleX, offsetY+float64(maxY)*scaleY)
+			}
+
+			if cfg.PerimeterFirst {
+				// Cut the region's edge once before filling it so the
+				// infill has a clean border to register against.
+				t.off(tp)
+				boundary := traceRegionBoundary(region.points)
+				perimeter := pointVecs(simplifyPath(boundary.points, cfg.Tolerance))
+				if cfg.MergeCollinear > 0 {
+					perimeter = mergeCollinear(perimeter, cfg.MergeCollinear)
+				}
+				cutPath(perimeter, offsetX, offsetY, scaleX, scaleY, arcTolerance, ramp, t, tp)
+				t.off(tp)
+			}
+
+			points := infill[i]
+			if len(points) == 0 {
+				continue
+			}
+			if cfg.FillMode == FillConcentric {
+				fillConcentric(points, offsetX, offsetY, scaleX, scaleY, float64(lineSpacing)*scaleY, t, tp)
+				continue
+			}
+			minX, minY, maxX, maxY := getBoundingBox(points)
+			fillOptimizedZigZag(minX, minY, maxX, maxY, points, offsetX, offsetY, scaleX, scaleY, lineSpacing, crossSpacing, angle, cfg.MinSegment, cfg.Overscan, cfg.Serpentine, corners[i], t, tp)
+		}
+	}
+
+	if toEmit == 0 {
+		cfg.progress(StageGCode, 1)
+	}
+	return nil
+}
+
+// cutPath travels to the first of points, given in pixels, engages t and
+// cuts through the rest, leaving the tool engaged at the end. A non-zero
+// arcTolerance fits arcs to runs of points lying on a circle within that
+// distance, between the feed ramps of ramp, and cuts at ramp's cutting feed.
+func cutPath(points []vec, offsetX, offsetY, scaleX, scaleY, arcTolerance float64, ramp feedRamp, t tool, tp *Toolpath) {
+	if len(points) == 0 {
+		return
+	}
+
+	scaled := make([]vec, len(points))
+	for i, point := range points {
+		scaled[i] = vec{offsetX + point.x*scaleX, offsetY + point.y*scaleY}
+	}
+
+	tp.travel(scaled[0].x, scaled[0].y)
+	t.on(tp)
+
+	body := func(run []vec) []Move {
+		if arcTolerance > 0 {
+			return fitArcs(run, arcTolerance)
+		}
+		moves := make([]Move, 0, len(run)-1)
+		for _, p := range run[1:] {
+			moves = append(moves, Move{Type: MoveCut, X: p.x, Y: p.y})
+		}
+		return moves
+	}
+	for _, m := range rampCuts(scaled, ramp, body) {
+		if m.Feed == 0 && ramp.cut != tp.CutFeed {
+			m.Feed = ramp.cut
+		}
+		tp.add(m)
+	}
+}
+
+// tool engages and disengages whatever is doing the work: the laser, fired
+// at power, or in CNC mode a cutter plunged to depth and lifted to safeZ.
+type tool struct {
+	cnc        bool
+	power      int
+	depth      float64
+	safeZ      float64
+	plungeFeed float64
+}
+
+// newTool returns the tool for the given outline pass of cfg; CNC passes
+// after the first go PassDepth deeper each.
+func newTool(cfg Config, pass int) tool {
+	return tool{
+		cnc:        cfg.CNC,
+		power:      cfg.Power,
+		depth:      cfg.CutDepth + float64(pass)*cfg.PassDepth,
+		safeZ:      cfg.SafeZ,
+		plungeFeed: cfg.PlungeFeed,
+	}
+}
+
+func (t tool) on(tp *Toolpath) {
+	if t.cnc {
+		tp.plunge(-t.depth, t.plungeFeed)
+		return
+	}
+	tp.laserOn(t.power)
+}
+
+func (t tool) off(tp *Toolpath) {
+	if t.cnc {
+		tp.retract(t.safeZ)
+		return
+	}
+	tp.laserOff()
+}
+
+// GCode serializes the toolpath as a laser program, taking dialect
+// settings such as the laser mode from cfg.
+func (tp *Toolpath) GCode(cfg Config) string {
+	var sb strings.Builder
+	tp.WriteGCode(&sb, cfg)
+	return sb.String()
+}
+
+// WriteGCode writes the toolpath to w as a laser program: units and absolute
+// mode header, the moves, then laser off and a return to the park position.
+func (tp *Toolpath) WriteGCode(w io.Writer, cfg Config) error {
+	cfg = cfg.withDefaults()
+	bw := bufio.NewWriter(w)
+	gw := newGCodeWriter(bw, tp, cfg)
+
+	gw.header()
+	for _, m := range tp.Moves {
+		gw.move(m)
+	}
+	gw.footer()
 
-		minX, minY, maxX, maxY := getBoundingBox(region.points)
-		fillOptimizedZigZag(minX, minY, maxX, maxY, region.points, offset, scaleX, scaleY, &sb)
+	return bw.Flush()
+}
+
+// gcodeWriter turns moves into G-code lines, tracking the modal feed so F is
+// only written when it changes. Write errors are left for the caller to
+// collect from the bufio.Writer's Flush.
+type gcodeWriter struct {
+	w       *bufio.Writer
+	tp      *Toolpath
+	feed    float64
+	prec    int    // decimal places for coordinates
+	laserOn string // M3 for constant power, M4 for dynamic
+	endCmd  string
+	airCmd  string // M7 or M8 when air assist brackets the job
+	cfg     Config
+
+	// In relative mode, the position the program has moved to so far,
+	// rounded as written so the steps add up exactly, whether each axis
+	// has been placed absolutely yet and whether G91 is in force.
+	x, y, z     float64
+	placedXY    bool
+	placedZ     bool
+	incremental bool
+}
+
+func newGCodeWriter(w *bufio.Writer, tp *Toolpath, cfg Config) *gcodeWriter {
+	g := &gcodeWriter{w: w, tp: tp, feed: tp.CutFeed, prec: 3, laserOn: "M3", endCmd: cfg.EndCommand, cfg: cfg}
+	if cfg.LaserMode == LaserDynamic {
+		g.laserOn = "M4"
+	}
+	if cfg.AirAssist {
+		g.airCmd = cfg.AirAssistCommand
+	}
+	if tp.Units == UnitsInch {
+		// A thousandth of an inch is 25µm; one more place keeps inch
+		// output at least as fine as the millimetre default.
+		g.prec = 4
+	}
+	if cfg.Precision != nil {
+		g.prec = *cfg.Precision
+	}
+	return g
+}
+
+// header writes the units, positioning mode and default feeds, or the
+// user's header template in their place, after the homing cycle and work
+// zero when asked for. A raw program has none.
+func (g *gcodeWriter) header() {
+	if g.cfg.Raw {
+		// Nothing states a feed before the first cut.
+		g.feed = -1
+		return
+	}
+	if g.cfg.Comments {
+		g.comments()
+	}
+	if g.cfg.Home {
+		g.w.WriteString("$H\n")
+	}
+	if g.cfg.SetZero {
+		// Zero the work coordinates at wherever the head now is: home,
+		// or where the operator jogged it to.
+		g.w.WriteString("G10 L20 P1 X0 Y0\n")
+	}
+
+	if g.cfg.Header != "" {
+		g.template(g.cfg.Header)
+		// The template may not set a feed, so the first cut states one.
+		g.feed = -1
+	} else {
+		units := "G21"
+		if g.tp.Units == UnitsInch {
+			units = "G20"
+		}
+		fmt.Fprintf(g.w, "%s\nG90\n%s\nG0 F%g\nG1 F%g\n", units, g.toolOff(), g.tp.TravelFeed, g.tp.CutFeed)
+		if g.cfg.CNC {
+			g.z, g.placedZ = g.round(g.cfg.SafeZ), true
+		}
+	}
+
+	// Air assist stays on for the whole job rather than following the
+	// laser, to avoid chattering the solenoid between paths.
+	if g.airCmd != "" {
+		g.w.WriteString(g.airCmd + "\n")
+	}
+}
+
+func (g *gcodeWriter) move(m Move) {
+	// In relative mode moves stay absolute up to the first one in X and
+	// Y, which switches the program to G91; from then on moves are steps
+	// from the last position. A Z move before the axis has been placed is
+	// still written absolute, with G90 for that move only.
+	if g.cfg.Relative {
+		var absolute bool
+		switch m.Type {
+		case MoveTravel, MoveCut, MoveArcCW, MoveArcCCW, MoveOverscan:
+			absolute = !g.incremental
+			m.X, m.Y = g.stepXY(m.X, m.Y, absolute)
+		case MovePlunge, MoveRetract:
+			absolute = !g.incremental || !g.placedZ
+			m.Z = g.stepZ(m.Z, absolute)
+		}
+		if absolute && (g.incremental || m.positioned()) {
+			g.w.WriteString("G90 ")
+			g.incremental = true
+			defer g.w.WriteString("G91\n")
+		}
+	}
+
+	switch m.Type {
+	case MoveTravel:
+		fmt.Fprintf(g.w, "G0 X%.*f Y%.*f\n", g.prec, m.X, g.prec, m.Y)
+	case MoveCut, MoveOverscan:
+		fmt.Fprintf(g.w, "G1 X%.*f Y%.*f%s\n", g.prec, m.X, g.prec, m.Y, g.feedWord(m))
+	case MoveArcCW, MoveArcCCW:
+		code := "G2"
+		if m.Type == MoveArcCCW {
+			code = "G3"
+		}
+		fmt.Fprintf(g.w, "%s X%.*f Y%.*f I%.*f J%.*f%s\n", code, g.prec, m.X, g.prec, m.Y, g.prec, m.I, g.prec, m.J, g.feedWord(m))
+	case MoveLaserOn:
+		fmt.Fprintf(g.w, "%s S%d\n", g.laserOn, m.Power)
+		if g.cfg.Dwell > 0 {
+			// GRBL reads P as seconds, not the milliseconds of the setting.
+			fmt.Fprintf(g.w, "G4 P%s\n", strconv.FormatFloat(float64(g.cfg.Dwell)/1000, 'f', -1, 64))
+		}
+	case MoveLaserOff:
+		g.w.WriteString("M5\n")
+	case MovePlunge:
+		if m.Feed > 0 && m.Feed != g.feed {
+			fmt.Fprintf(g.w, "G1 Z%.*f F%g\n", g.prec, m.Z, m.Feed)
+			g.feed = m.Feed
+		} else {
+			fmt.Fprintf(g.w, "G1 Z%.*f\n", g.prec, m.Z)
+		}
+	case MoveRetract:
+		fmt.Fprintf(g.w, "G0 Z%.*f\n", g.prec, m.Z)
+	case MovePause:
+		g.w.WriteString("M0\n")
+	case MoveComment:
+		fmt.Fprintf(g.w, "; %s\n", m.Text)
+	case MoveDwell:
+		fmt.Fprintf(g.w, "G4 P%s\n", strconv.FormatFloat(m.Time, 'f', -1, 64))
 	}
+}
 
-	sb.WriteString("M5\nG0 X0 Y0\n")
-	return sb.String(), nil
+// comments writes the header comment: where the job comes from, its size
+// and the settings that shape the burn.
+func (g *gcodeWriter) comments() {
+	units := g.tp.Units
+	if units == "" {
+		units = UnitsMM
+	}
+	if g.cfg.Source != "" {
+		fmt.Fprintf(g.w, "; Source: %s\n", g.cfg.Source)
+	}
+	fmt.Fprintf(g.w, "; Size: %.*f x %.*f %s at X%.*f Y%.*f\n", g.prec, g.cfg.Width, g.prec, g.cfg.Height, units, g.prec, g.cfg.OffsetX, g.prec, g.cfg.OffsetY)
+	fmt.Fprintf(g.w, "; Settings: power S%d, feed %g, travel %g %s/min, %d outline and %d fill passes\n",
+		g.cfg.Power, g.tp.CutFeed, g.tp.TravelFeed, units, g.cfg.Passes, g.cfg.FillPasses)
+}
+
+// stepXY returns the X and Y words for a relative-mode move to x, y: the
+// step from the previous position, or x, y themselves when the move is
+// written absolute.
+func (g *gcodeWriter) stepXY(x, y float64, absolute bool) (float64, float64) {
+	x, y = g.round(x), g.round(y)
+	dx, dy := x-g.x, y-g.y
+	if absolute {
+		dx, dy = x, y
+	}
+	g.x, g.y, g.placedXY = x, y, true
+	return dx, dy
+}
+
+// stepZ is stepXY for the Z axis.
+func (g *gcodeWriter) stepZ(z float64, absolute bool) float64 {
+	z = g.round(z)
+	dz := z - g.z
+	if absolute {
+		dz = z
+	}
+	g.z, g.placedZ = z, true
+	return dz
+}
+
+// round rounds v to the places coordinates are written with.
+func (g *gcodeWriter) round(v float64) float64 {
+	scale := math.Pow(10, float64(g.prec))
+	return math.Round(v*scale) / scale
+}
+
+// feedWord returns the F word a cutting move needs, or "" when the modal
+// feed already matches.
+func (g *gcodeWriter) feedWord(m Move) string {
+	want := g.tp.CutFeed
+	if m.Feed > 0 {
+		want = m.Feed
+	}
+	if want == g.feed {
+		return ""
+	}
+	g.feed = want
+	return fmt.Sprintf(" F%g", want)
+}
+
+// footer turns the laser (or lifts the cutter) and air assist off, then
+// returns to the park position and ends the program. A footer template
+// replaces the return and end command, but the tool is always made safe
+// first so the head never travels with the beam on or the bit down. A raw
+// program stops once the tool is safe.
+func (g *gcodeWriter) footer() {
+	if g.cfg.Relative {
+		// The tool lift, park and any footer template are absolute.
+		g.w.WriteString("G90\n")
+	}
+	g.w.WriteString(g.toolOff() + "\n")
+	if g.cfg.Raw {
+		return
+	}
+	if g.airCmd != "" {
+		g.w.WriteString("M9\n")
+	}
+	if g.cfg.Footer != "" {
+		g.template(g.cfg.Footer)
+		return
+	}
+	if g.tp.ReturnX == 0 && g.tp.ReturnY == 0 {
+		g.w.WriteString("G0 X0 Y0\n")
+	} else {
+		fmt.Fprintf(g.w, "G0 X%.*f Y%.*f\n", g.prec, g.tp.ReturnX, g.prec, g.tp.ReturnY)
+	}
+	if g.endCmd != EndNone {
+		g.w.WriteString(g.endCmd + "\n")
+	}
+}
+
+// toolOff is the command that makes the tool safe before and after the
+// job: laser off, or in CNC mode the cutter lifted to the safe height.
+func (g *gcodeWriter) toolOff() string {
+	if g.cfg.CNC {
+		return fmt.Sprintf("G0 Z%.*f", g.prec, g.cfg.SafeZ)
+	}
+	return "M5"
+}
+
+// template writes a user header or footer verbatim after expanding the
+// {WIDTH}, {HEIGHT}, {FEED}, {TRAVELFEED} and {POWER} tokens.
+func (g *gcodeWriter) template(text string) {
+	r := strings.NewReplacer(
+		"{WIDTH}", fmt.Sprintf("%g", g.cfg.Width),
+		"{HEIGHT}", fmt.Sprintf("%g", g.cfg.Height),
+		"{FEED}", fmt.Sprintf("%g", g.tp.CutFeed),
+		"{TRAVELFEED}", fmt.Sprintf("%g", g.tp.TravelFeed),
+		"{POWER}", fmt.Sprintf("%d", g.cfg.Power),
+	)
+
+	text = r.Replace(text)
+	if !strings.HasSuffix(text, "\n") {
+		text += "\n"
+	}
+	g.w.WriteString(text)
 }
 
 type Point struct {
@@ -63,6 +789,74 @@ type Path struct {
 	points []Point
 }
 
+// dropShortPaths removes traced paths with fewer than minPoints points,
+// which are almost always noise.
+func dropShortPaths(paths []Path, minPoints int) []Path {
+	kept := paths[:0]
+	for _, path := range paths {
+		if len(path.points) >= minPoints {
+			kept = append(kept, path)
+		}
+	}
+	return kept
+}
+
+// pointVecs converts pixel points to vectors.
+func pointVecs(points []Point) []vec {
+	vecs := make([]vec, len(points))
+	for i, p := range points {
+		vecs[i] = vec{float64(p.x), float64(p.y)}
+	}
+	return vecs
+}
+
+// defaultCloseGap is the default Config.CloseGap: tracing an outline round
+// often stops a pixel or two short of where it began.
+const defaultCloseGap = 2
+
+// closeLoop closes the polyline pts when its end stops short of its start by
+// no more than gap pixels, as traced outlines often do, so the last nick
+// gets cut too. The start is appended as a final point, or with snap the end
+// is moved onto it instead. Open runs with a wider gap, and polylines too
+// short to enclose anything, are returned as they are.
+func closeLoop(pts []vec, gap float64, snap bool) []vec {
+	if len(pts) < 3 {
+		return pts
+	}
+	start, end := pts[0], pts[len(pts)-1]
+	if start == end || math.Hypot(end.x-start.x, end.y-start.y) > gap {
+		return pts
+	}
+
+	closed := append([]vec(nil), pts...)
+	if snap {
+		closed[len(closed)-1] = start
+		return closed
+	}
+	return append(closed, start)
+}
+
+// mergeCollinear drops every point of pts that lies within tolerance of the
+// segment joining its neighbours, judged against the points kept so far,
+// until no more can go, so straight runs become a single cut. The ends are
+// always kept.
+func mergeCollinear(pts []vec, tolerance float64) []vec {
+	for len(pts) > 2 {
+		merged := []vec{pts[0]}
+		for i := 1; i < len(pts)-1; i++ {
+			if distanceToSegment(pts[i], merged[len(merged)-1], pts[i+1]) > tolerance {
+				merged = append(merged, pts[i])
+			}
+		}
+		merged = append(merged, pts[len(pts)-1])
+		if len(merged) == len(pts) {
+			break
+		}
+		pts = merged
+	}
+	return pts
+}
+
 func simplifyPath(points []Point, tolerance float64) []Point {
 	if len(points) < 3 {
 		return points
@@ -86,77 +880,130 @@ func simplifyPath(points []Point, tolerance float64) []Point {
 	return result
 }
 
-func extractOutlinePaths(img image.Image, threshold uint8) []Path {
+func extractOutlinePaths(img image.Image, background uint8) []Path {
 	bounds := img.Bounds()
 	width, height := bounds.Dx(), bounds.Dy()
-	visited := make([][]bool, height)
-	for i := range visited {
-		visited[i] = make([]bool, width)
-	}
+	// Only pixels on a traced contour are claimed, so a contour met later
+	// in the scan, such as the rim of a hole, can still be walked all the
+	// way round.
+	claimed := newBitset(width * height)
 
 	var paths []Path
 
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
-			if visited[y][x] {
+			if claimed.get(y*width + x) {
 				continue
 			}
 
-			gray := getGrayscale(img, bounds, x, y)
-			if gray >= 230 {
-				visited[y][x] = true
-				continue
-			}
-
-			if isEdgePixel(img, bounds, x, y) {
-				path := tracePath(img, bounds, x, y, visited)
+			if isEdgePixel(img, bounds, x, y, true, background) {
+				path := tracePath(img, bounds, x, y, claimed, background)
 				paths = append(paths, path)
 			}
-
-			visited[y][x] = true
 		}
 	}
 
 	return paths
 }
 
-func extractFillRegions(img image.Image, threshold uint8) []Path {
+// extractFillRegions returns the regions of img to fill, from a raster scan
+// starting a flood fill at every interior pixel not yet in a region. A fill
+// spreads over the foreground but not back over pixels the scan has passed,
+// so a region starts at its seed and the edge pixels before it are left out.
+//
+// Regions never spread beyond their foreground component, so the
+// components are flooded concurrently, each scanned in raster order, and
+// the regions put back in the order of their seeds: the result is exactly
+// that of a single scan.
+func extractFillRegions(img image.Image, background uint8) []Path {
 	bounds := img.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
-	visited := make([][]bool, height)
-	for i := range visited {
-		visited[i] = make([]bool, width)
-	}
-
-	var regions []Path
+	return fillRegions(classifyPixels(img, background), bounds.Dx(), bounds.Dy(), runtime.NumCPU())
+}
 
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			if visited[y][x] {
-				continue
+// fillRegions is extractFillRegions on the classes of a width×height image,
+// split over the given number of workers.
+func fillRegions(classes []uint8, width, height, workers int) []Path {
+	components := interiorByComponent(classes, width, height, workers)
+
+	// Components never share a pixel, so neither do their fills.
+	visited := make([]bool, width*height)
+	found := make([][]Path, len(components))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := min(workers, len(components)); w > 0; w-- {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := int(next.Add(1) - 1); i < len(components); i = int(next.Add(1) - 1) {
+				for _, seed := range components[i] {
+					if !visited[seed] {
+						found[i] = append(found[i], floodFill(classes, width, height, seed, visited))
+					}
+				}
 			}
+		}()
+	}
+	wg.Wait()
 
-			gray := getGrayscale(img, bounds, x, y)
-			if gray >= 230 {
-				visited[y][x] = true
-				continue
-			}
+	regions := slices.Concat(found...)
+	slices.SortFunc(regions, func(a, b Path) int {
+		pa, pb := a.points[0], b.points[0]
+		return (pa.y*width + pa.x) - (pb.y*width + pb.x)
+	})
+	return regions
+}
 
-			if !isEdgePixel(img, bounds, x, y) {
-				region := floodFill(img, bounds, x, y, visited)
-				regions = append(regions, region)
-			}
+// Pixel classes for fill extraction.
+const (
+	pixelBackground = iota
+	pixelEdge       // foreground bordering the background
+	pixelInterior   // foreground away from any edge
+)
 
-			visited[y][x] = true
-		}
+// classifyPixels returns the fill class of every pixel of img, row by row,
+// taking levels at or above background as background.
+// The image border is not a feature edge here, so shapes running off the
+// edge of the image still get filled. Horizontal bands of the image are
+// classified concurrently, one per CPU.
+func classifyPixels(img image.Image, background uint8) []uint8 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	classes := make([]uint8, width*height)
+
+	workers := min(runtime.NumCPU(), max(height, 1))
+	band := (height + workers - 1) / workers
+
+	var wg sync.WaitGroup
+	for top := 0; top < height; top += band {
+		wg.Add(1)
+		go func(top, bottom int) {
+			defer wg.Done()
+			for y := top; y < bottom; y++ {
+				for x := 0; x < width; x++ {
+					switch {
+					case getGrayscale(img, bounds, x, y) >= int(background):
+						classes[y*width+x] = pixelBackground
+					case isEdgePixel(img, bounds, x, y, false, background):
+						classes[y*width+x] = pixelEdge
+					default:
+						classes[y*width+x] = pixelInterior
+					}
+				}
+			}
+		}(top, min(top+band, height))
 	}
+	wg.Wait()
 
-	return regions
+	return classes
 }
 
-func isEdgePixel(img image.Image, bounds image.Rectangle, x, y int) bool {
+// isEdgePixel reports whether the foreground pixel at x, y borders the
+// background, the levels at or above background. With borderIsEdge set,
+// pixels on the image border count as edges too, as if the image were
+// surrounded by background.
+func isEdgePixel(img image.Image, bounds image.Rectangle, x, y int, borderIsEdge bool, background uint8) bool {
 	gray := getGrayscale(img, bounds, x, y)
-	if gray >= 230 {
+	if gray >= int(background) {
 		return false
 	}
 
@@ -168,11 +1015,14 @@ func isEdgePixel(img image.Image, bounds image.Rectangle, x, y int) bool {
 	for _, dir := range directions {
 		nx, ny := x+dir.dx, y+dir.dy
 		if nx < 0 || ny < 0 || nx >= bounds.Dx() || ny >= bounds.Dy() {
-			return true
+			if borderIsEdge {
+				return true
+			}
+			continue
 		}
 
 		neighborGray := getGrayscale(img, bounds, nx, ny)
-		if neighborGray >= 230 {
+		if neighborGray >= int(background) {
 			return true
 		}
 	}
@@ -180,64 +1030,61 @@ func isEdgePixel(img image.Image, bounds image.Rectangle, x, y int) bool {
 	return false
 }
 
-func tracePath(img image.Image, bounds image.Rectangle, startX, startY int, visited [][]bool) Path {
-	path := Path{
-		points: []Point{{startX, startY}},
+// tracePath follows the contour through the edge pixel startX, startY with
+// Moore neighbour tracing and returns it as a closed path. Pixels outside
+// the image count as background. The walk stops short of pixels already
+// claimed by an earlier contour, and claims its own.
+func tracePath(img image.Image, bounds image.Rectangle, startX, startY int, claimed bitset, background uint8) Path {
+	inside := func(p Point) bool {
+		if p.x < 0 || p.y < 0 || p.x >= bounds.Dx() || p.y >= bounds.Dy() {
+			return false
+		}
+		return getGrayscale(img, bounds, p.x, p.y) < int(background)
 	}
-
-	visited[startY][startX] = true
-
-	directions := []struct{ dx, dy int }{
-		{-1, 0}, {1, 0}, {0, -1}, {0, 1},
-		{-1, -1}, {-1, 1}, {1, -1}, {1, 1},
+	start := Point{startX, startY}
+	stop := func(p Point) bool { return p != start && claimed.get(p.y*bounds.Dx()+p.x) }
+
+	// Enter from the first background neighbour; an edge pixel always has
+	// one.
+	from := 0
+	for d, n := range mooreNeighbors {
+		if !inside(Point{start.x + n.x, start.y + n.y}) {
+			from = d
+			break
+		}
 	}
 
-	x, y := startX, startY
-	foundNext := true
-
-	for foundNext {
-		foundNext = false
-		bestDistance := math.MaxFloat64
-		var nextX, nextY int
-
-		for _, dir := range directions {
-			nx, ny := x+dir.dx, y+dir.dy
-			if nx < 0 || ny < 0 || nx >= bounds.Dx() || ny >= bounds.Dy() {
-				continue
-			}
+	points := mooreTrace(start, from, inside, stop, 4*bounds.Dx()*bounds.Dy())
+	for _, p := range points {
+		claimed.set(p.y*bounds.Dx() + p.x)
+	}
 
-			if visited[ny][nx] {
-				continue
+	// A start off the loop, such as the corner pixel the scan meets first
+	// on the rim of a hole, is never walked back to; the walk ends where
+	// it comes round again instead, and the contour is the loop from
+	// there.
+	if last := points[len(points)-1]; last != start {
+		for i, p := range points[:len(points)-1] {
+			if p == last {
+				points = points[i:]
+				break
 			}
-
-			nGray := getGrayscale(img, bounds, nx, ny)
-			if nGray < 230 && isEdgePixel(img, bounds, nx, ny) {
-				dist := math.Hypot(float64(nx-x), float64(ny-y))
-				if dist < bestDistance {
-					bestDistance = dist
-					nextX, nextY = nx, ny
-					foundNext = true
-				}
-			}
-		}
-
-		if foundNext {
-			x, y = nextX, nextY
-			path.points = append(path.points, Point{x, y})
-			visited[y][x] = true
 		}
 	}
-
-	return path
+	return Path{points: points}
 }
 
-func floodFill(img image.Image, bounds image.Rectangle, startX, startY int, visited [][]bool) Path {
+// floodFill returns the region seeded at pixel index seed of classes: the
+// foreground pixels reachable from it without passing through one that
+// comes before it in raster order, marking them visited.
+func floodFill(classes []uint8, width, height, seed int, visited []bool) Path {
+	startX, startY := seed%width, seed/width
 	region := Path{
 		points: []Point{{startX, startY}},
 	}
 
 	queue := []Point{{startX, startY}}
-	visited[startY][startX] = true
+	visited[seed] = true
 
 	directions := []struct{ dx, dy int }{
 		{-1, 0}, {1, 0}, {0, -1}, {0, 1},
@@ -249,19 +1096,20 @@ func floodFill(img image.Image, bounds image.Rectangle, startX, startY int, visi
 
 		for _, dir := range directions {
 			nx, ny := curr.x+dir.dx, curr.y+dir.dy
-			if nx < 0 || ny < 0 || nx >= bounds.Dx() || ny >= bounds.Dy() {
+			if nx < 0 || ny < 0 || nx >= width || ny >= height {
 				continue
 			}
 
-			if visited[ny][nx] {
+			// The scan has already passed pixels before the seed.
+			n := ny*width + nx
+			if n < seed || visited[n] {
 				continue
 			}
 
-			gray := getGrayscale(img, bounds, nx, ny)
-			if gray < 230 {
+			if classes[n] != pixelBackground {
 				region.points = append(region.points, Point{nx, ny})
 				queue = append(queue, Point{nx, ny})
-				visited[ny][nx] = true
+				visited[n] = true
 			}
 		}
 	}
@@ -295,73 +1143,392 @@ func getBoundingBox(points []Point) (int, int, int, int) {
 	return minX, minY, maxX, maxY
 }
 
-func fillOptimizedZigZag(minX, minY, maxX, maxY int, points []Point, offset, scaleX, scaleY float64, sb *strings.Builder) {
-	pointMap := make(map[int]map[int]bool)
-	for _, p := range points {
-		if _, ok := pointMap[p.y]; !ok {
-			pointMap[p.y] = make(map[int]bool)
+// erodeRegion returns the pixels of points lying more than depth pixels in
+// from the region's edge: depth rounds of dropping every pixel with a
+// neighbour, diagonals included, outside the region. A single round takes
+// off the edge pixels outlines are traced along.
+func erodeRegion(points []Point, depth int) []Point {
+	for ; depth > 0 && len(points) > 0; depth-- {
+		inside := make(map[Point]bool, len(points))
+		for _, p := range points {
+			inside[p] = true
 		}
-		pointMap[p.y][p.x] = true
+
+		kept := make([]Point, 0, len(points))
+		for _, p := range points {
+			interior := true
+			for _, d := range mooreNeighbors {
+				if !inside[Point{p.x + d.x, p.y + d.y}] {
+					interior = false
+					break
+				}
+			}
+			if interior {
+				kept = append(kept, p)
+			}
+		}
+		points = kept
 	}
+	return points
+}
 
-	lineSpacing := 3
+// defaultMinFill is the fewest pixels a fill region needs to be filled when
+// no minimum area is given.
+const defaultMinFill = 200
 
-	for y := minY; y <= maxY; y += lineSpacing {
-		fromRight := (y-minY)%2 == 1
-		var segments []struct{ startX, endX int }
+// minFillPixels converts a minimum fill area in mm² into pixels at the given
+// scales, or returns defaultMinFill when area is not positive.
+func minFillPixels(area, scaleX, scaleY float64) int {
+	if area <= 0 {
+		return defaultMinFill
+	}
+	return int(math.Ceil(area / (scaleX * scaleY)))
+}
 
-		startSegment := -1
+// defaultLineSpacing is the fill pitch in pixels used when neither a line
+// spacing nor a spot size is given.
+const defaultLineSpacing = 3
+
+// fillPitch returns the distance in pixels between fill scan lines along an
+// axis with the given scale: the explicit line spacing if set, otherwise the
+// spot size, otherwise defaultLineSpacing.
+func fillPitch(lineSpacingMM, spotSize, scale float64) int {
+	switch {
+	case lineSpacingMM > 0:
+		return pixelSpacing(lineSpacingMM, scale)
+	case spotSize > 0:
+		// Scan lines one spot diameter apart just touch: a wider pitch
+		// leaves unburned gaps, a narrower one burns the overlap twice.
+		return pixelSpacing(spotSize, scale)
+	default:
+		return defaultLineSpacing
+	}
+}
 
-		if fromRight {
-			for x := maxX; x >= minX; x-- {
-				if pointMap[y] != nil && pointMap[y][x] {
-					if startSegment == -1 {
-						startSegment = x
-					}
-				} else if startSegment != -1 {
-					segments = append(segments, struct{ startX, endX int }{x + 1, startSegment})
-					startSegment = -1
-				}
+// pixelSpacing converts a pitch in mm into whole pixels along an axis with
+// the given scale. Pitches finer than a pixel round up to one pixel.
+func pixelSpacing(mm, scale float64) int {
+	spacing := int(math.Round(mm / scale))
+	if spacing < 1 {
+		return 1
+	}
+	return spacing
+}
+
+// fillOptimizedZigZag hatches a region with horizontal scan lines
+// lineSpacing rows apart, alternating the scan direction on each line. When
+// crossSpacing is non-zero a second, vertical pass crossSpacing columns apart
+// is added on top for a denser cross-hatched burn. A non-zero angle turns
+// the scan lines that many degrees from the X axis towards Y, in pixels.
+// corner picks the end of the region the first scan line runs from.
+func fillOptimizedZigZag(minX, minY, maxX, maxY int, points []Point, offsetX, offsetY, scaleX, scaleY float64, lineSpacing, crossSpacing int, angle, minSegment, overscan float64, serpentine bool, corner fillCorner, t tool, tp *Toolpath) {
+	runs := newRowRuns(points)
+
+	lines := &lineBurner{offsetX: offsetX, offsetY: offsetY, scaleX: scaleX, scaleY: scaleY, overscan: overscan, t: t, tp: tp}
+	if serpentine && overscan <= 0 {
+		lines.inside = runs.contains
+	}
+	defer lines.finish()
+
+	if angle != 0 {
+		fillAngled(points, runs, scaleX, scaleY, lineSpacing, angle, minSegment, corner, lines)
+		lines.finish()
+		if crossSpacing != 0 {
+			fillAngled(points, runs, scaleX, scaleY, crossSpacing, angle+90, minSegment, fillCorner{}, lines)
+		}
+		return
+	}
+
+	// A region no taller than one scan pitch would only have its edge row
+	// burned, and one too narrow for any row segment to pass the length
+	// filter would be dropped entirely, so thin regions get a single pass
+	// along their centerline instead. The filter is the one the rows go
+	// through, so it follows MinSegment.
+	width, height := maxX-minX+1, maxY-minY+1
+	switch {
+	case height <= lineSpacing && width > height:
+		row := (minY + maxY) / 2
+		centerY := offsetY + float64(minY+maxY)/2*scaleY
+		for _, seg := range runs.spans(row, false) {
+			if shortSegment(seg, scaleX, minSegment) {
+				continue
 			}
-			if startSegment != -1 {
-				segments = append(segments, struct{ startX, endX int }{minX, startSegment})
+
+			burnLine(offsetX+float64(seg.start)*scaleX, centerY, offsetX+float64(seg.end)*scaleX, centerY, overscan, t, tp)
+		}
+		return
+	case height > width && shortSegment(span{minX, maxX}, scaleX, minSegment):
+		col := (minX + maxX) / 2
+		centerX := offsetX + float64(minX+maxX)/2*scaleX
+		for _, seg := range scanSpans(minY, maxY, false, func(y int) bool { return runs.contains(col, y) }) {
+			if shortSegment(seg, scaleY, minSegment) {
+				continue
 			}
-		} else {
-			for x := minX; x <= maxX; x++ {
-				if pointMap[y] != nil && pointMap[y][x] {
-					if startSegment == -1 {
-						startSegment = x
-					}
-				} else if startSegment != -1 {
-					segments = append(segments, struct{ startX, endX int }{startSegment, x - 1})
-					startSegment = -1
-				}
+
+			burnLine(centerX, offsetY+float64(seg.start)*scaleY, centerX, offsetY+float64(seg.end)*scaleY, overscan, t, tp)
+		}
+		return
+	}
+
+	rows := (maxY-minY)/lineSpacing + 1
+	for k := 0; k < rows; k++ {
+		y := minY + corner.line(k, rows)*lineSpacing
+		fromRight := (k%2 == 1) != corner.right
+		segments := runs.spans(y, fromRight)
+
+		for _, seg := range segments {
+			if shortSegment(seg, scaleX, minSegment) {
+				continue
 			}
-			if startSegment != -1 {
-				segments = append(segments, struct{ startX, endX int }{startSegment, maxX})
+
+			start, end := lines.ends(seg, fromRight)
+			lines.burn(vec{float64(start), float64(y)}, vec{float64(end), float64(y)})
+		}
+	}
+
+	if crossSpacing == 0 {
+		return
+	}
+	// The cross pass runs the other way; none of its lines continues a
+	// row.
+	lines.finish()
+
+	for x := minX; x <= maxX; x += crossSpacing {
+		fromBottom := ((x-minX)/crossSpacing)%2 == 1
+		segments := scanSpans(minY, maxY, fromBottom, func(y int) bool {
+			return runs.contains(x, y)
+		})
+
+		for _, seg := range segments {
+			if shortSegment(seg, scaleY, minSegment) {
+				continue
 			}
+
+			start, end := lines.ends(seg, fromBottom)
+			lines.burn(vec{float64(x), float64(start)}, vec{float64(x), float64(end)})
 		}
+	}
+}
+
+// fillAngled hatches the region in runs with zigzag scan lines turned
+// angle degrees from the X axis, burnt by lines. It scans the region in a
+// frame rotated by -angle, where the lines are horizontal, sampling the
+// region at each whole position of that frame so the rotated region has no
+// gaps, and turns the ends of every span back into image coordinates.
+// corner is as for fillOptimizedZigZag, in the rotated frame.
+func fillAngled(points []Point, runs rowRuns, scaleX, scaleY float64, lineSpacing int, angle, minSegment float64, corner fillCorner, lines *lineBurner) {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	// One step along the scan line, in output units.
+	pitch := math.Hypot(cos*scaleX, sin*scaleY)
+	toImage := func(u, v int) (float64, float64) {
+		return float64(u)*cos - float64(v)*sin, float64(u)*sin + float64(v)*cos
+	}
+
+	minU, minV, maxU, maxV := scanBounds(points, sin, cos)
+
+	count := (maxV-minV)/lineSpacing + 1
+	for k := 0; k < count; k++ {
+		v := minV + corner.line(k, count)*lineSpacing
+		fromRight := (k%2 == 1) != corner.right
+		segments := scanSpans(minU, maxU, fromRight, func(u int) bool {
+			x, y := toImage(u, v)
+			return runs.contains(int(math.Round(x)), int(math.Round(y)))
+		})
 
 		for _, seg := range segments {
-			if seg.endX-seg.startX < 3 {
+			if shortSegment(seg, pitch, minSegment) {
 				continue
 			}
 
-			startX := offset + float64(seg.startX)*scaleX
-			startY := offset + float64(y)*scaleY
-			endX := offset + float64(seg.endX)*scaleX
+			start, end := lines.ends(seg, fromRight)
+			startX, startY := toImage(start, v)
+			endX, endY := toImage(end, v)
 
-			sb.WriteString(fmt.Sprintf("G0 X%.3f Y%.3f\nM3 S1000\n", startX, startY))
-			sb.WriteString(fmt.Sprintf("G1 X%.3f Y%.3f\n", endX, startY))
-			sb.WriteString("M5\n")
+			lines.burn(vec{startX, startY}, vec{endX, endY})
 		}
 	}
 }
 
+// scanBounds returns the bounding box of points, widened to whole pixels, in
+// the frame turned by the angle whose sine and cosine are given, where that
+// angle's scan lines run along u.
+func scanBounds(points []Point, sin, cos float64) (minU, minV, maxU, maxV int) {
+	minU, minV, maxU, maxV = math.MaxInt, math.MaxInt, math.MinInt, math.MinInt
+	for _, p := range points {
+		u := float64(p.x)*cos + float64(p.y)*sin
+		v := -float64(p.x)*sin + float64(p.y)*cos
+		minU, maxU = min(minU, int(math.Floor(u))), max(maxU, int(math.Ceil(u)))
+		minV, maxV = min(minV, int(math.Floor(v))), max(maxV, int(math.Ceil(v)))
+	}
+	return minU, minV, maxU, maxV
+}
+
+// fillCorner is the corner of a region's scan frame a zigzag fill starts
+// from. The zero value starts at the top left: the first scan line is the
+// one nearest the origin and runs away from it.
+type fillCorner struct {
+	bottom bool // scan the lines last to first
+	right  bool // run the first line from its far end
+}
+
+// line returns the index of the k-th of n scan lines to burn.
+func (c fillCorner) line(k, n int) int {
+	if c.bottom {
+		return n - 1 - k
+	}
+	return k
+}
+
+// lineBurner burns a region's fill lines one after another, given in
+// pixels. With inside set, reporting whether a pixel belongs to the region,
+// a line that starts where a straight move from the end of the one before
+// stays within the region is joined to it with the tool still on, the
+// boustrophedon a solid block is filled with, instead of switching the tool
+// off, travelling and switching it on again. The join burns the region's
+// edge between the lines too. Lines with an overscan are never joined.
+type lineBurner struct {
+	offsetX, offsetY, scaleX, scaleY float64
+	overscan                         float64
+	t                                tool
+	tp                               *Toolpath
+	inside                           func(x, y int) bool
+
+	on   bool // the tool is still on at last
+	last vec
+}
+
+func (b *lineBurner) burn(from, to vec) {
+	if b.on && b.joins(b.last, from) {
+		b.tp.cut(b.offsetX+from.x*b.scaleX, b.offsetY+from.y*b.scaleY)
+		b.tp.cut(b.offsetX+to.x*b.scaleX, b.offsetY+to.y*b.scaleY)
+		b.last = to
+		return
+	}
+	b.finish()
+
+	x0, y0 := b.offsetX+from.x*b.scaleX, b.offsetY+from.y*b.scaleY
+	x1, y1 := b.offsetX+to.x*b.scaleX, b.offsetY+to.y*b.scaleY
+	if b.inside == nil {
+		burnLine(x0, y0, x1, y1, b.overscan, b.t, b.tp)
+		return
+	}
+	b.tp.travel(x0, y0)
+	b.t.on(b.tp)
+	b.tp.cut(x1, y1)
+	b.on, b.last = true, to
+}
+
+// ends returns the ends of seg, found scanning in reverse or not, in the
+// order to burn them. Spans are burnt low end first, unless lines are being
+// joined: then a reversed line runs back the way it was scanned, from where
+// the one before it ended.
+func (b *lineBurner) ends(seg span, reverse bool) (int, int) {
+	if reverse && b.inside != nil {
+		return seg.end, seg.start
+	}
+	return seg.start, seg.end
+}
+
+// joins reports whether the straight move from a to b runs through the
+// region's pixels only.
+func (b *lineBurner) joins(a, c vec) bool {
+	steps := int(math.Ceil(math.Hypot(c.x-a.x, c.y-a.y)))
+	for i := 0; i <= steps; i++ {
+		f := float64(i) / float64(max(steps, 1))
+		if !b.inside(int(math.Round(a.x+(c.x-a.x)*f)), int(math.Round(a.y+(c.y-a.y)*f))) {
+			return false
+		}
+	}
+	return true
+}
+
+// finish switches the tool off after a joined run, so the next line starts
+// afresh.
+func (b *lineBurner) finish() {
+	if b.on {
+		b.t.off(b.tp)
+		b.on = false
+	}
+}
+
+// burnLine burns one fill line from x0, y0 to x1, y1. With a positive
+// overscan the head starts that far before the line and runs on that far
+// past it with the tool off, so it is up to cutting speed over the whole
+// burn instead of accelerating into it.
+func burnLine(x0, y0, x1, y1, overscan float64, t tool, tp *Toolpath) {
+	length := math.Hypot(x1-x0, y1-y0)
+	if overscan <= 0 || length == 0 {
+		tp.travel(x0, y0)
+		t.on(tp)
+		tp.cut(x1, y1)
+		t.off(tp)
+		return
+	}
+
+	dx, dy := (x1-x0)/length*overscan, (y1-y0)/length*overscan
+	tp.travel(x0-dx, y0-dy)
+	tp.overscan(x0, y0)
+	t.on(tp)
+	tp.cut(x1, y1)
+	t.off(tp)
+	tp.overscan(x1+dx, y1+dy)
+}
+
+// shortSegment reports whether the fill span seg, along an axis with the
+// given scale, is too short to be worth burning: under minSegment output
+// units long, or when minSegment is not positive, under three pixels.
+func shortSegment(seg span, scale, minSegment float64) bool {
+	if minSegment > 0 {
+		return float64(seg.end-seg.start)*scale < minSegment
+	}
+	return seg.end-seg.start < 3
+}
+
+// span is an inclusive run of pixels along one scan line.
+type span struct {
+	start, end int
+}
+
+// scanSpans walks one scan line from lo to hi (or hi to lo when reverse is
+// set) and returns the runs of pixels for which inside reports true, in the
+// order they are met. Each span is always given low end first.
+func scanSpans(lo, hi int, reverse bool, inside func(int) bool) []span {
+	var spans []span
+	startSegment := -1
+
+	if reverse {
+		for i := hi; i >= lo; i-- {
+			if inside(i) {
+				if startSegment == -1 {
+					startSegment = i
+				}
+			} else if startSegment != -1 {
+				spans = append(spans, span{i + 1, startSegment})
+				startSegment = -1
+			}
+		}
+		if startSegment != -1 {
+			spans = append(spans, span{lo, startSegment})
+		}
+	} else {
+		for i := lo; i <= hi; i++ {
+			if inside(i) {
+				if startSegment == -1 {
+					startSegment = i
+				}
+			} else if startSegment != -1 {
+				spans = append(spans, span{startSegment, i - 1})
+				startSegment = -1
+			}
+		}
+		if startSegment != -1 {
+			spans = append(spans, span{startSegment, hi})
+		}
+	}
+
+	return spans
+}
+
 func getGrayscale(img image.Image, bounds image.Rectangle, x, y int) int {
-	r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
-	r8 := uint8(r >> 8)
-	g8 := uint8(g >> 8)
-	b8 := uint8(b >> 8)
-	return (299*int(r8) + 587*int(g8) + 114*int(b8)) / 1000
+	return int(LumaGray.Gray(img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()))
 }
@@ -0,0 +1,1372 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// wavyPath returns a traced-looking path along a sine wave, one pixel
+// apart along X.
+func wavyPath(n int) []Point {
+	pts := make([]Point, n)
+	for i := range pts {
+		pts[i] = Point{i, int(math.Round(3 * math.Sin(float64(i)/5)))}
+	}
+	return pts
+}
+
+func TestSimplifyPathTolerance(t *testing.T) {
+	path := wavyPath(100)
+
+	if got := len(simplifyPath(path, 0)); got != len(path) {
+		t.Errorf("tolerance 0 kept %d of %d points", got, len(path))
+	}
+	if got := len(simplifyPath(path, 0.5)); got != len(path) {
+		t.Errorf("tolerance 0.5 kept %d of %d points", got, len(path))
+	}
+
+	prev := len(path)
+	for _, tol := range []float64{1, 2, 4} {
+		got := simplifyPath(path, tol)
+		if len(got) >= prev {
+			t.Errorf("tolerance %v kept %d points, want fewer than %d", tol, len(got), prev)
+		}
+		if got[0] != path[0] || got[len(got)-1] != path[len(path)-1] {
+			t.Errorf("tolerance %v moved the path's ends", tol)
+		}
+		prev = len(got)
+	}
+}
+
+func TestToleranceZeroIsKept(t *testing.T) {
+	if got := (Config{}).withDefaults().Tolerance; got != 0 {
+		t.Errorf("withDefaults turned tolerance 0 into %v", got)
+	}
+	if got := DefaultConfig().Tolerance; got != 1 {
+		t.Errorf("default tolerance = %v, want 1", got)
+	}
+}
+
+// annulus returns a size×size image, black between radii inner and outer
+// of its centre and white elsewhere; inner 0 gives a filled disc.
+func annulus(size int, inner, outer float64) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, size, size))
+	c := float64(size-1) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			d := math.Hypot(float64(x)-c, float64(y)-c)
+			if d >= inner && d <= outer {
+				img.SetGray(x, y, color.Gray{0})
+			} else {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return img
+}
+
+// pathLength returns the length of the polyline through points.
+func pathLength(points []Point) float64 {
+	var l float64
+	for i := 1; i < len(points); i++ {
+		l += math.Hypot(float64(points[i].x-points[i-1].x), float64(points[i].y-points[i-1].y))
+	}
+	return l
+}
+
+// checkClosedContour fails t unless p is a closed loop about as long as a
+// circle of radius r.
+func checkClosedContour(t *testing.T, p Path, r float64) {
+	t.Helper()
+	first, last := p.points[0], p.points[len(p.points)-1]
+	if first != last {
+		t.Errorf("contour of radius %v runs from %v to %v, want it closed", r, first, last)
+	}
+	if l, want := pathLength(p.points), 2*math.Pi*r; math.Abs(l-want) > want/10 {
+		t.Errorf("contour of radius %v is %.1f long, want about %.1f", r, l, want)
+	}
+}
+
+func TestOutlineOfDiscIsClosed(t *testing.T) {
+	paths := dropShortPaths(extractOutlinePaths(annulus(100, 0, 30), 128), 3)
+	if len(paths) != 1 {
+		t.Fatalf("got %d contours, want 1", len(paths))
+	}
+	checkClosedContour(t, paths[0], 30)
+}
+
+func TestOutlineOfHoleIsClosed(t *testing.T) {
+	paths := dropShortPaths(extractOutlinePaths(annulus(100, 20, 35), 128), 3)
+	if len(paths) != 2 {
+		t.Fatalf("got %d contours, want 2", len(paths))
+	}
+	checkClosedContour(t, paths[0], 35)
+	checkClosedContour(t, paths[1], 20)
+}
+
+// rect returns a w×h white image with black from x0, y0 up to x1, y1.
+func rect(w, h, x0, y0, x1, y1 int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{255})
+			if x >= x0 && x < x1 && y >= y0 && y < y1 {
+				img.SetGray(x, y, color.Gray{0})
+			}
+		}
+	}
+	return img
+}
+
+func TestNothingToEngraveWhiteImage(t *testing.T) {
+	_, err := ConvertToGCode(rect(50, 50, 0, 0, 0, 0), DefaultConfig())
+	if !errors.Is(err, ErrNothingToEngrave) {
+		t.Fatalf("err = %v, want ErrNothingToEngrave", err)
+	}
+}
+
+func TestNothingToEngraveUnderMinimumSizes(t *testing.T) {
+	// A bar three pixels thick has a fill region, but one under the
+	// default minimum fill, and an outline shorter than the minimum path.
+	img := rect(100, 100, 20, 49, 80, 52)
+	cfg := DefaultConfig()
+	if _, err := ConvertToGCode(img, cfg); err != nil {
+		t.Fatalf("default minimum path: %v", err)
+	}
+	cfg.MinPathPoints = 1000
+	if _, err := ConvertToGCode(img, cfg); !errors.Is(err, ErrNothingToEngrave) {
+		t.Fatalf("err = %v, want ErrNothingToEngrave", err)
+	}
+}
+
+func TestBurnLineOverscanIsNotBurnt(t *testing.T) {
+	cfg := DefaultConfig()
+	tp := newToolpath(cfg)
+	burnLine(10, 5, 30, 5, 3, newTool(cfg, 0), tp)
+
+	var types []MoveType
+	for _, m := range tp.Moves {
+		types = append(types, m.Type)
+	}
+	want := []MoveType{MoveTravel, MoveOverscan, MoveLaserOn, MoveCut, MoveLaserOff, MoveOverscan}
+	if len(types) != len(want) {
+		t.Fatalf("got moves %v, want %v", types, want)
+	}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("got moves %v, want %v", types, want)
+		}
+	}
+	if first, last := tp.Moves[0], tp.Moves[len(tp.Moves)-1]; first.X != 7 || last.X != 33 {
+		t.Errorf("overscan runs from X%v to X%v, want X7 to X33", first.X, last.X)
+	}
+
+	stats := tp.Stats()
+	if stats.CutDistance != 20 {
+		t.Errorf("cut distance %v, want only the 20 of the line", stats.CutDistance)
+	}
+	if stats.TravelDistance < 6 {
+		t.Errorf("travel distance %v leaves out the overscan", stats.TravelDistance)
+	}
+}
+
+func TestFillPitchFromSpotSize(t *testing.T) {
+	const scale = 0.5 // mm per pixel
+	small, large := fillPitch(0, 1, scale), fillPitch(0, 3, scale)
+	if small != 2 || large != 6 {
+		t.Errorf("spots of 1 and 3 mm give pitches of %d and %d pixels, want 2 and 6", small, large)
+	}
+	if got := fillPitch(2, 3, scale); got != 4 {
+		t.Errorf("line spacing 2 mm with a 3 mm spot gives %d pixels, want the spacing's 4", got)
+	}
+	if got := fillPitch(0, 0, scale); got != defaultLineSpacing {
+		t.Errorf("no spacing or spot gives %d pixels, want %d", got, defaultLineSpacing)
+	}
+}
+
+// squares returns a w×h white image with a black s×s square at each corner
+// given.
+func squares(w, h, s int, corners ...[2]int) *image.Gray {
+	img := rect(w, h, 0, 0, 0, 0)
+	for _, c := range corners {
+		for y := c[1]; y < c[1]+s; y++ {
+			for x := c[0]; x < c[0]+s; x++ {
+				img.SetGray(x, y, color.Gray{0})
+			}
+		}
+	}
+	return img
+}
+
+func TestOptimizeShortensTravel(t *testing.T) {
+	// In raster order the head crosses the image twice; nearest first it
+	// goes down, then across once.
+	img := squares(100, 100, 12, [2]int{5, 5}, [2]int{80, 6}, [2]int{8, 40})
+	cfg := DefaultConfig()
+	plain, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Optimize = true
+	optimized, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, o := plain.Stats(), optimized.Stats()
+	if o.TravelDistance >= p.TravelDistance {
+		t.Errorf("optimized travel %.1f, want less than the %.1f of raster order", o.TravelDistance, p.TravelDistance)
+	}
+	if math.Abs(o.CutDistance-p.CutDistance) > 1e-6 {
+		t.Errorf("optimizing changed the cut distance from %.1f to %.1f", p.CutDistance, o.CutDistance)
+	}
+}
+
+// countMoves counts the moves of tp of type typ.
+func countMoves(tp *Toolpath, typ MoveType) int {
+	n := 0
+	for _, m := range tp.Moves {
+		if m.Type == typ {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLineSpacingSetsScanLines(t *testing.T) {
+	img := rect(60, 60, 10, 10, 50, 50)
+	lines := func(spacing float64) int {
+		cfg := DefaultConfig()
+		cfg.Width, cfg.Height = 60, 60
+		cfg.LineSpacing = spacing
+		tp, err := BuildToolpath(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// One laser-on for the outline, one per scan line.
+		return countMoves(tp, MoveLaserOn) - 1
+	}
+	fine, coarse := lines(1), lines(4)
+	if fine < 3*coarse {
+		t.Errorf("spacing 1 mm gives %d scan lines and 4 mm %d, want about four times as many", fine, coarse)
+	}
+}
+
+// longCuts counts the horizontal and vertical cuts of tp longer than min.
+func longCuts(tp *Toolpath, min float64) (horizontal, vertical int) {
+	var x, y float64
+	for _, m := range tp.Moves {
+		if !m.positioned() {
+			continue
+		}
+		if m.Type == MoveCut {
+			switch {
+			case m.Y == y && math.Abs(m.X-x) > min:
+				horizontal++
+			case m.X == x && math.Abs(m.Y-y) > min:
+				vertical++
+			}
+		}
+		x, y = m.X, m.Y
+	}
+	return horizontal, vertical
+}
+
+func TestCrosshatchAddsVerticalLines(t *testing.T) {
+	img := annulus(60, 0, 25)
+	cfg := DefaultConfig()
+	cfg.Width, cfg.Height = 60, 60
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, v := longCuts(tp, 15); h == 0 || v != 0 {
+		t.Errorf("without crosshatch: %d horizontal and %d vertical lines, want only horizontal", h, v)
+	}
+
+	cfg.Crosshatch = true
+	tp, err = BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, v := longCuts(tp, 15); h == 0 || v == 0 {
+		t.Errorf("with crosshatch: %d horizontal and %d vertical lines, want both", h, v)
+	}
+}
+
+func TestWriteGCodeMatchesBuiltToolpath(t *testing.T) {
+	img := squares(80, 80, 20, [2]int{5, 5}, [2]int{50, 40}, [2]int{10, 55})
+	variants := map[string]func(*Config){
+		"default":  func(*Config) {},
+		"optimize": func(c *Config) { c.Optimize = true },
+		"cnc":      func(c *Config) { c.CNC, c.Passes = true, 2 },
+		"relative": func(c *Config) { c.Relative, c.Arcs = true, true },
+		"guards": func(c *Config) {
+			c.BedWidth, c.BedHeight, c.ClipToBed = 60, 60, true
+			c.TravelSafe = 10
+		},
+	}
+	for name, set := range variants {
+		cfg := DefaultConfig()
+		set(&cfg)
+
+		var buf bytes.Buffer
+		stats, err := WriteGCodeStats(&buf, img, cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		tp, err := BuildToolpath(img, cfg)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if buf.String() != tp.GCode(cfg) {
+			t.Errorf("%s: streamed G-code differs from the built toolpath's", name)
+		}
+		if stats != tp.Stats() {
+			t.Errorf("%s: streamed stats %+v, built %+v", name, stats, tp.Stats())
+		}
+	}
+}
+
+func TestWideRegionIsFilled(t *testing.T) {
+	img := rect(1000, 5, 0, 1, 1000, 4)
+	cfg := DefaultConfig()
+	cfg.Width, cfg.Height = 1000, 5
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The outline is traced in short steps; only a fill line runs the
+	// length of the bar in one cut.
+	if h, _ := longCuts(tp, 900); h == 0 {
+		t.Error("the 1000×3 bar got no fill line")
+	}
+}
+
+// coordDecimals returns the set of decimal place counts used by the X and Y
+// words of gcode.
+func coordDecimals(gcode string) map[int]bool {
+	places := map[int]bool{}
+	for _, word := range regexp.MustCompile(`[XY]-?\d+(\.\d*)?`).FindAllStringSubmatch(gcode, -1) {
+		places[max(len(word[1])-1, 0)] = true
+	}
+	return places
+}
+
+func TestInchUnits(t *testing.T) {
+	img := rect(40, 40, 10, 10, 30, 30)
+	cfg := DefaultConfig()
+	cfg.Units, cfg.Width, cfg.Height = UnitsInch, 2, 2
+	gcode, err := ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(gcode, "G20\n") || strings.Contains(gcode, "G21") {
+		t.Error("inch program does not select inches with G20 alone")
+	}
+	// The final return to the origin is written as X0 Y0.
+	if places := coordDecimals(strings.ReplaceAll(gcode, "X0 Y0", "")); len(places) != 1 || !places[4] {
+		t.Errorf("inch coordinates have %v decimal places, want 4", places)
+	}
+
+	gcode, err = ConvertToGCode(img, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(gcode, "G21\n") {
+		t.Error("mm program does not select millimetres with G21")
+	}
+	if places := coordDecimals(strings.ReplaceAll(gcode, "X0 Y0", "")); len(places) != 1 || !places[3] {
+		t.Errorf("mm coordinates have %v decimal places, want 3", places)
+	}
+}
+
+func TestLaserModeCommands(t *testing.T) {
+	img := squares(60, 60, 20, [2]int{5, 5}, [2]int{35, 35})
+	for mode, want := range map[string]string{LaserConstant: "M3", LaserDynamic: "M4"} {
+		cfg := DefaultConfig()
+		cfg.LaserMode = mode
+		gcode, err := ConvertToGCode(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := map[string]string{"M3": "M4", "M4": "M3"}[want]
+		if n := strings.Count(gcode, want+" S"); n < 2 {
+			t.Errorf("%s mode switches the laser on with %s only %d times", mode, want, n)
+		}
+		if strings.Contains(gcode, other) {
+			t.Errorf("%s mode uses %s", mode, other)
+		}
+	}
+}
+
+// lastLine returns the last non-empty line of s.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	return lines[len(lines)-1]
+}
+
+func TestEndCommand(t *testing.T) {
+	img := rect(40, 40, 10, 10, 30, 30)
+	for _, end := range []string{EndM2, EndM30, EndNone} {
+		cfg := DefaultConfig()
+		cfg.EndCommand = end
+		gcode, err := ConvertToGCode(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		last := lastLine(gcode)
+		switch end {
+		case EndNone:
+			if last == EndM2 || last == EndM30 {
+				t.Errorf("no end command asked for, program ends with %s", last)
+			}
+		default:
+			if last != end {
+				t.Errorf("program ends with %q, want %s", last, end)
+			}
+		}
+	}
+}
+
+func TestAirAssist(t *testing.T) {
+	img := rect(40, 40, 10, 10, 30, 30)
+	cfg := DefaultConfig()
+	gcode, err := ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(gcode, "M8") || strings.Contains(gcode, "M9") {
+		t.Error("air assist commands without -airassist")
+	}
+
+	cfg.AirAssist = true
+	gcode, err = ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimRight(gcode, "\n"), "\n")
+	on, off := slices.Index(lines, "M8"), slices.Index(lines, "M9")
+	firstBurn := slices.IndexFunc(lines, func(l string) bool { return strings.HasPrefix(l, "M3 ") })
+	lastBurn := 0
+	for i, l := range lines {
+		if strings.HasPrefix(l, "G1 ") {
+			lastBurn = i
+		}
+	}
+	if on < 0 || on > firstBurn {
+		t.Errorf("M8 at line %d, want it before the first burn at %d", on, firstBurn)
+	}
+	if off < lastBurn || off < len(lines)-4 {
+		t.Errorf("M9 at line %d of %d, want it after the last cut at %d, near the end", off, len(lines), lastBurn)
+	}
+}
+
+func TestShapeAtImageEdgeIsFilled(t *testing.T) {
+	for name, tc := range map[string]struct {
+		img  *image.Gray
+		edge Point // a pixel of the shape on the image border
+	}{
+		"left edge": {rect(40, 40, 0, 10, 20, 30), Point{0, 20}},
+		"corner":    {rect(40, 40, 20, 20, 40, 40), Point{39, 39}},
+		"whole":     {rect(40, 40, 0, 0, 40, 40), Point{0, 0}},
+	} {
+		regions := extractFillRegions(tc.img, 128)
+		if len(regions) != 1 {
+			t.Errorf("%s: %d fill regions, want 1", name, len(regions))
+			continue
+		}
+		if !slices.Contains(regions[0].points, tc.edge) {
+			t.Errorf("%s: fill stops short of the image border at %v", name, tc.edge)
+		}
+	}
+}
+
+func TestCNCUsesZInsteadOfLaser(t *testing.T) {
+	img := rect(40, 40, 10, 10, 30, 30)
+	cfg := DefaultConfig()
+	cfg.CNC = true
+	gcode, err := ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, laser := range []string{"M3", "M4", "M5"} {
+		if strings.Contains(gcode, laser) {
+			t.Errorf("CNC program uses %s", laser)
+		}
+	}
+	plunges := strings.Count(gcode, "G1 Z-0.500")
+	retracts := strings.Count(gcode, "G0 Z3.000")
+	if plunges == 0 || retracts < plunges {
+		t.Errorf("%d plunges to the cut depth and %d retracts to the safe height, want one of each per cut", plunges, retracts)
+	}
+}
+
+func TestNoTravelWhileEngaged(t *testing.T) {
+	img := squares(80, 80, 20, [2]int{5, 5}, [2]int{50, 40}, [2]int{10, 55})
+	for _, cnc := range []bool{false, true} {
+		cfg := DefaultConfig()
+		cfg.CNC, cfg.Passes = cnc, 2
+		tp, err := BuildToolpath(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		on, z := false, cfg.SafeZ
+		for i, m := range tp.Moves {
+			switch m.Type {
+			case MoveLaserOn:
+				on = true
+			case MoveLaserOff:
+				on = false
+			case MovePlunge, MoveRetract:
+				z = m.Z
+			case MoveTravel:
+				if on || z < cfg.SafeZ {
+					t.Fatalf("cnc %v: move %d travels to X%v Y%v with the tool engaged", cnc, i, m.X, m.Y)
+				}
+			}
+		}
+	}
+}
+
+// firstBurns returns, for each line of gcode starting with marker, the
+// points of the first burn after it: where the head stood when the laser
+// came on, then the end of every cut until it went off.
+func firstBurns(gcode, marker string) [][][2]float64 {
+	var burns [][][2]float64
+	var x, y float64
+	var burn [][2]float64
+	state := 0 // 0 outside, 1 after the marker, 2 burning
+	for _, line := range strings.Split(gcode, "\n") {
+		var nx, ny float64
+		if _, err := fmt.Sscanf(line[min(3, len(line)):], "X%f Y%f", &nx, &ny); err == nil {
+			x, y = nx, ny
+		}
+		switch {
+		case strings.HasPrefix(line, marker):
+			state = 1
+		case state == 1 && (strings.HasPrefix(line, "M3") || strings.HasPrefix(line, "M4")):
+			state, burn = 2, [][2]float64{{x, y}}
+		case state == 2 && strings.HasPrefix(line, "G1 X"):
+			burn = append(burn, [2]float64{x, y})
+		case state == 2 && strings.HasPrefix(line, "M5"):
+			burns = append(burns, burn)
+			state = 0
+		}
+	}
+	return burns
+}
+
+func TestPerimeterFirst(t *testing.T) {
+	img := squares(100, 100, 30, [2]int{10, 10}, [2]int{55, 50})
+	cfg := DefaultConfig()
+	cfg.Comments = true
+	gcode, err := ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, burn := range firstBurns(gcode, "; Fill region") {
+		if len(burn) != 2 {
+			t.Errorf("without -perimeter-first, region %d starts with a %d-point burn, want a fill line", i+1, len(burn))
+		}
+	}
+
+	cfg.PerimeterFirst = true
+	gcode, err = ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	burns := firstBurns(gcode, "; Fill region")
+	if len(burns) != 2 {
+		t.Fatalf("%d fill regions, want 2", len(burns))
+	}
+	for i, burn := range burns {
+		if len(burn) < 5 || burn[0] != burn[len(burn)-1] {
+			t.Errorf("region %d starts with a %d-point burn from %v to %v, want a closed perimeter", i+1, len(burn), burn[0], burn[len(burn)-1])
+		}
+	}
+}
+
+func TestProgressReportsStages(t *testing.T) {
+	type call struct {
+		stage    string
+		fraction float64
+	}
+	var calls []call
+	cfg := DefaultConfig()
+	cfg.Progress = func(stage string, fraction float64) {
+		calls = append(calls, call{stage, fraction})
+	}
+	if _, err := ConvertToGCode(squares(80, 80, 20, [2]int{5, 5}, [2]int{50, 50}), cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(calls) < 3 {
+		t.Fatalf("progress called %d times, want at least once per stage", len(calls))
+	}
+	if calls[0] != (call{StageOutlines, progressOutlines}) || calls[1] != (call{StageFills, progressFills}) {
+		t.Errorf("first calls %v, want outlines at %v then fills at %v", calls[:2], progressOutlines, progressFills)
+	}
+	for i, c := range calls[2:] {
+		if c.stage != StageGCode {
+			t.Errorf("call %d is for stage %q, want %q", i+2, c.stage, StageGCode)
+		}
+	}
+	for i := 1; i < len(calls); i++ {
+		if calls[i].fraction < calls[i-1].fraction {
+			t.Errorf("fraction fell from %v to %v", calls[i-1].fraction, calls[i].fraction)
+		}
+	}
+	if last := calls[len(calls)-1]; last.fraction != 1 {
+		t.Errorf("last fraction %v, want 1", last.fraction)
+	}
+}
+
+// classifyPixelsSequential is classifyPixels on a single goroutine, the
+// reference the parallel version must match.
+func classifyPixelsSequential(img image.Image, background uint8) []uint8 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	classes := make([]uint8, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			switch {
+			case getGrayscale(img, bounds, x, y) >= int(background):
+				classes[y*width+x] = pixelBackground
+			case isEdgePixel(img, bounds, x, y, false, background):
+				classes[y*width+x] = pixelEdge
+			default:
+				classes[y*width+x] = pixelInterior
+			}
+		}
+	}
+	return classes
+}
+
+// speckled returns a w×h image of a ring with scattered dark pixels around
+// it, the same on every call.
+func speckled(w, h int) *image.Gray {
+	img := annulus(max(w, h), float64(max(w, h))/6, float64(max(w, h))/3)
+	img.Rect = image.Rect(0, 0, w, h)
+	img.Pix = img.Pix[:h*img.Stride]
+	for i := 0; i < len(img.Pix); i += 37 {
+		img.Pix[i] = 0
+	}
+	return img
+}
+
+func TestClassifyPixelsMatchesSequential(t *testing.T) {
+	for _, size := range [][2]int{{1, 1}, {7, 3}, {120, 97}, {64, 300}} {
+		img := speckled(size[0], size[1])
+		if !slices.Equal(classifyPixels(img, 128), classifyPixelsSequential(img, 128)) {
+			t.Errorf("%dx%d: parallel classes differ from sequential ones", size[0], size[1])
+		}
+	}
+}
+
+func BenchmarkClassifyPixels(b *testing.B) {
+	img := speckled(1000, 1000)
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			classifyPixels(img, 128)
+		}
+	})
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			classifyPixelsSequential(img, 128)
+		}
+	})
+}
+
+// extractFillRegionsSequential is extractFillRegions as a single raster
+// scan, the reference the concurrent version must match.
+func extractFillRegionsSequential(img image.Image, background uint8) []Path {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	classes := classifyPixelsSequential(img, background)
+	visited := newBitset(width * height)
+	var regions []Path
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if !visited.get(y*width+x) && classes[y*width+x] == pixelInterior {
+				region := Path{points: []Point{{x, y}}}
+				visited.set(y*width + x)
+				for i := 0; i < len(region.points); i++ {
+					p := region.points[i]
+					for _, n := range []Point{{p.x - 1, p.y}, {p.x + 1, p.y}, {p.x, p.y - 1}, {p.x, p.y + 1}} {
+						if n.x < 0 || n.y < 0 || n.x >= width || n.y >= height {
+							continue
+						}
+						if !visited.get(n.y*width+n.x) && classes[n.y*width+n.x] != pixelBackground {
+							visited.set(n.y*width + n.x)
+							region.points = append(region.points, n)
+						}
+					}
+				}
+				regions = append(regions, region)
+			}
+			visited.set(y*width + x)
+		}
+	}
+	return regions
+}
+
+// blobs returns a w×h image of size×size squares on a grid, with a thin
+// line along the top of every other row of them joining them up.
+func blobs(w, h, size int) *image.Gray {
+	img := rect(w, h, 0, 0, 0, 0)
+	for y := 1; y+size < h; y += 2 * size {
+		for x := 1; x+size < w; x += 2 * size {
+			for dy := 0; dy < size; dy++ {
+				for dx := 0; dx < size; dx++ {
+					img.SetGray(x+dx, y+dy, color.Gray{0})
+				}
+			}
+		}
+		if (y/(2*size))%2 == 0 {
+			for x := 1; x < w-1; x++ {
+				img.SetGray(x, y-1, color.Gray{0})
+			}
+		}
+	}
+	return img
+}
+
+func TestExtractFillRegionsMatchesSequential(t *testing.T) {
+	for name, img := range map[string]*image.Gray{
+		"speckled 1x1":     speckled(1, 1),
+		"speckled 7x3":     speckled(7, 3),
+		"speckled 120x97":  speckled(120, 97),
+		"speckled 64x300":  speckled(64, 300),
+		"blobs":            blobs(200, 170, 9),
+		"tall blobs":       blobs(40, 500, 6),
+		"ring":             annulus(90, 10, 40),
+		"image-wide":       rect(50, 50, 0, 0, 50, 50),
+		"empty":            rect(30, 30, 0, 0, 0, 0),
+		"squares at edges": squares(60, 60, 20, [2]int{0, 0}, [2]int{40, 40}, [2]int{20, 19}),
+	} {
+		want := extractFillRegionsSequential(img, 128)
+		classes := classifyPixels(img, 128)
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		// One band has no boundaries to stitch across.
+		whole := interiorByComponent(classes, w, h, 1)
+		for _, workers := range []int{1, 2, 3, 7} {
+			if !slices.EqualFunc(interiorByComponent(classes, w, h, workers), whole, slices.Equal) {
+				t.Errorf("%s, %d workers: components not stitched back together across the bands", name, workers)
+			}
+			got := fillRegions(classes, w, h, workers)
+			if !slices.EqualFunc(got, want, func(a, b Path) bool { return slices.Equal(a.points, b.points) }) {
+				t.Errorf("%s, %d workers: %d regions differ from the %d of a single scan", name, workers, len(got), len(want))
+			}
+		}
+	}
+}
+
+func BenchmarkExtractFillRegions(b *testing.B) {
+	for name, img := range map[string]*image.Gray{
+		"speckled": speckled(1000, 1000),
+		"blobs":    blobs(1000, 1000, 12),
+	} {
+		b.Run(name+"/parallel", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				extractFillRegions(img, 128)
+			}
+		})
+		b.Run(name+"/sequential", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				extractFillRegionsSequential(img, 128)
+			}
+		})
+	}
+}
+
+func TestBackgroundThresholdDecidesLightGray(t *testing.T) {
+	img := rect(60, 60, 15, 15, 45, 45)
+	for i, v := range img.Pix {
+		if v == 0 {
+			img.Pix[i] = 200
+		}
+	}
+	cfg := DefaultConfig()
+	cfg.BackgroundThreshold = 230
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatalf("threshold 230: %v", err)
+	}
+	if countMoves(tp, MoveCut) == 0 {
+		t.Error("threshold 230: the gray square was not engraved")
+	}
+	cfg.BackgroundThreshold = 150
+	if _, err := BuildToolpath(img, cfg); !errors.Is(err, ErrNothingToEngrave) {
+		t.Errorf("threshold 150: err = %v, want ErrNothingToEngrave", err)
+	}
+}
+
+func TestMinFillArea(t *testing.T) {
+	img := rect(100, 100, 30, 30, 70, 70)
+	cuts := func(area float64) int {
+		cfg := DefaultConfig()
+		cfg.Width, cfg.Height = 100, 100
+		cfg.MinFillArea = area
+		tp, err := BuildToolpath(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return countMoves(tp, MoveCut)
+	}
+	// The 40mm square covers about 1600mm².
+	if filled, outlined := cuts(500), cuts(2000); filled <= outlined {
+		t.Errorf("%d cuts with a 500mm² minimum fill, want more than the %d with 2000mm²", filled, outlined)
+	}
+}
+
+func TestMinPathPoints(t *testing.T) {
+	img := rect(100, 100, 47, 47, 53, 53)
+	cfg := DefaultConfig()
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if countMoves(tp, MoveCut) == 0 {
+		t.Error("the 6px square's outline was not cut with the default minimum path")
+	}
+	cfg.MinPathPoints = 100
+	if _, err := BuildToolpath(img, cfg); !errors.Is(err, ErrNothingToEngrave) {
+		t.Errorf("err = %v with a 100-point minimum path, want ErrNothingToEngrave", err)
+	}
+}
+
+func TestFillAngle90IsVertical(t *testing.T) {
+	img := annulus(60, 0, 25)
+	cfg := DefaultConfig()
+	cfg.Width, cfg.Height = 60, 60
+	cfg.FillAngle = 90
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, v := longCuts(tp, 15); h != 0 || v == 0 {
+		t.Errorf("at 90°: %d horizontal and %d vertical lines, want only vertical", h, v)
+	}
+}
+
+func TestPassAngleJitter(t *testing.T) {
+	// passAngles returns the angle of the longest line of each fill pass.
+	passAngles := func(seed int64) []float64 {
+		cfg := DefaultConfig()
+		cfg.Width, cfg.Height = 60, 60
+		cfg.FillAngle, cfg.PassAngleJitter = 30, 10
+		cfg.FillPasses, cfg.Seed, cfg.Comments = 4, seed, true
+		tp, err := BuildToolpath(rect(60, 60, 5, 5, 55, 55), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var angles, longest []float64
+		var x, y float64
+		for _, m := range tp.Moves {
+			if m.Type == MoveComment && strings.HasPrefix(m.Text, "Fill region") {
+				angles, longest = append(angles, 0), append(longest, 0)
+			}
+			if !m.positioned() {
+				continue
+			}
+			if n := len(angles); n > 0 && m.Type == MoveCut {
+				if l := math.Hypot(m.X-x, m.Y-y); l > longest[n-1] {
+					longest[n-1] = l
+					angles[n-1] = math.Mod(math.Atan2(m.Y-y, m.X-x)*180/math.Pi+360, 180)
+				}
+			}
+			x, y = m.X, m.Y
+		}
+		return angles
+	}
+
+	angles := passAngles(1)
+	if len(angles) != 4 {
+		t.Fatalf("%d fill passes, want 4", len(angles))
+	}
+	for i, a := range angles {
+		if a < 20-0.5 || a > 40+0.5 {
+			t.Errorf("pass %d at %.2f°, want within 10° of 30°", i+1, a)
+		}
+		for _, b := range angles[:i] {
+			if math.Abs(a-b) < 0.01 {
+				t.Errorf("passes share the angle %.2f°: %v", a, angles)
+			}
+		}
+	}
+	if again := passAngles(1); !slices.Equal(again, angles) {
+		t.Errorf("the same seed turned the passes to %v, then %v", angles, again)
+	}
+	if other := passAngles(2); slices.Equal(other, angles) {
+		t.Errorf("seeds 1 and 2 both turned the passes to %v", angles)
+	}
+}
+
+func TestMinSegmentIsPhysical(t *testing.T) {
+	// A 2mm minimum at 0.1mm and at 1mm per pixel: the same lengths pass
+	// however many pixels they span.
+	for _, tc := range []struct {
+		scale  float64
+		pixels int
+		short  bool
+	}{
+		{0.1, 15, true},
+		{0.1, 25, false},
+		{1, 1, true},
+		{1, 3, false},
+	} {
+		seg := span{10, 10 + tc.pixels}
+		if got := shortSegment(seg, tc.scale, 2); got != tc.short {
+			t.Errorf("%d pixels at %vmm each: short = %v, want %v", tc.pixels, tc.scale, got, tc.short)
+		}
+	}
+}
+
+func TestSupersampleSmoothsDiagonal(t *testing.T) {
+	// A triangle whose long side climbs one pixel every two.
+	img := image.NewGray(image.Rect(0, 0, 60, 60))
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 60; x++ {
+			img.SetGray(x, y, color.Gray{255})
+			if x > 10 && x < 50 && y > 10 && y < 50 && 2*x > y+20 {
+				img.SetGray(x, y, color.Gray{0})
+			}
+		}
+	}
+	roughness := func(n int) float64 {
+		cfg := DefaultConfig()
+		cfg.Width, cfg.Height = 60, 60
+		cfg.NoFill = true
+		cfg.Tolerance = 0
+		cfg.Supersample = n
+		tp, err := BuildToolpath(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Fit x = a y + b through the points along the middle of the
+		// slanted side and return the farthest any strays from it.
+		var edge []vec
+		for _, m := range tp.Moves {
+			if m.Type == MoveCut && m.Y > 15 && m.Y < 45 && m.X < 45 && math.Abs(2*m.X-m.Y-20) < 4 {
+				edge = append(edge, vec{m.X, m.Y})
+			}
+		}
+		var sx, sy, syy, sxy float64
+		for _, p := range edge {
+			sx, sy, syy, sxy = sx+p.x, sy+p.y, syy+p.y*p.y, sxy+p.x*p.y
+		}
+		k := float64(len(edge))
+		a := (k*sxy - sx*sy) / (k*syy - sy*sy)
+		b := (sx - a*sy) / k
+		dev := 0.0
+		for _, p := range edge {
+			dev = math.Max(dev, math.Abs(p.x-a*p.y-b))
+		}
+		return dev
+	}
+	if native, doubled := roughness(1), roughness(2); doubled >= native {
+		t.Errorf("diagonal strays %.3f from straight at 2×, want less than the %.3f at 1×", doubled, native)
+	}
+}
+
+func TestCloseLoopTwoPixelGap(t *testing.T) {
+	open := []vec{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 2}}
+	closed := closeLoop(open, defaultCloseGap, false)
+	if len(closed) != len(open)+1 || closed[len(closed)-1] != open[0] {
+		t.Errorf("closed to %v, want the start appended", closed)
+	}
+	snapped := closeLoop(open, defaultCloseGap, true)
+	if len(snapped) != len(open) || snapped[len(snapped)-1] != open[0] {
+		t.Errorf("snapped to %v, want the end moved onto the start", snapped)
+	}
+	if wide := closeLoop(open, 1, false); len(wide) != len(open) {
+		t.Errorf("a 1px gap allowance closed the 2px gap: %v", wide)
+	}
+}
+
+func TestMergeCollinearStraightRow(t *testing.T) {
+	row := []vec{{0, 0}, {1, 0}, {2, 0}, {3, 0}, {4, 0}}
+	if merged := mergeCollinear(row, 0.01); !slices.Equal(merged, []vec{{0, 0}, {4, 0}}) {
+		t.Errorf("merged to %v, want just the ends", merged)
+	}
+}
+
+func TestOptimizeShortensFillTravel(t *testing.T) {
+	// Raster order fills the two top squares before coming back down for
+	// the one under the first.
+	img := squares(120, 120, 20, [2]int{5, 5}, [2]int{95, 6}, [2]int{8, 40})
+	fillTravel := func(optimize bool) float64 {
+		cfg := DefaultConfig()
+		cfg.Width, cfg.Height = 120, 120
+		cfg.Comments, cfg.Optimize = true, optimize
+		tp, err := BuildToolpath(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Sum the travel from the end of the outlines to the last fill.
+		var x, y, d float64
+		filling := false
+		for _, m := range tp.Moves {
+			if m.Type == MoveComment && strings.HasPrefix(m.Text, "Fill region") {
+				filling = true
+			}
+			if !m.positioned() {
+				continue
+			}
+			if filling && m.Type == MoveTravel {
+				d += math.Hypot(m.X-x, m.Y-y)
+			}
+			x, y = m.X, m.Y
+		}
+		return d
+	}
+	if plain, optimized := fillTravel(false), fillTravel(true); optimized >= plain {
+		t.Errorf("fills travel %.1f optimized, want less than the %.1f of raster order", optimized, plain)
+	}
+}
+
+func TestHomeAndSetZeroPreamble(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Home, cfg.SetZero = true, true
+	gcode, err := ConvertToGCode(rect(40, 40, 10, 10, 30, 30), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home, zero, units := strings.Index(gcode, "$H\n"), strings.Index(gcode, "G10 L20 P1 X0 Y0\n"), strings.Index(gcode, "G21")
+	if home < 0 || zero < 0 || !(home < zero && zero < units) {
+		t.Errorf("$H at %d, G10 at %d, G21 at %d; want homing, then zeroing, then the header", home, zero, units)
+	}
+
+	plain, err := ConvertToGCode(rect(40, 40, 10, 10, 30, 30), DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(plain, "$H") || strings.Contains(plain, "G10") {
+		t.Error("homing or zeroing written by default")
+	}
+}
+
+func TestDwellAfterLaserOn(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Dwell = 250
+	gcode, err := ConvertToGCode(squares(60, 60, 20, [2]int{5, 5}, [2]int{35, 35}), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(gcode, "\n")
+	var on int
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "M3 ") {
+			continue
+		}
+		on++
+		if i+1 >= len(lines) || lines[i+1] != "G4 P0.25" {
+			t.Fatalf("laser-on %q followed by %q, want G4 P0.25", line, lines[min(i+1, len(lines)-1)])
+		}
+	}
+	if on == 0 || strings.Count(gcode, "G4 ") != on {
+		t.Errorf("%d laser-ons and %d dwells, want one dwell each", on, strings.Count(gcode, "G4 "))
+	}
+}
+
+func TestNarrowRegionIsFilled(t *testing.T) {
+	// Rows across the 6mm-wide bar fall under the 8mm minimum segment.
+	img := rect(20, 200, 5, 0, 11, 200)
+	cfg := DefaultConfig()
+	cfg.Width, cfg.Height = 20, 200
+	cfg.MinSegment = 8
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, v := longCuts(tp, 190); v == 0 {
+		t.Error("the 6×200 bar got no fill line along it")
+	}
+}
+
+// positions returns where each X/Y move of gcode leaves the head, following
+// G90 and G91 to add up relative steps.
+func positions(gcode string) [][2]float64 {
+	var pos [][2]float64
+	var x, y float64
+	relative := false
+	for _, line := range strings.Split(gcode, "\n") {
+		words := strings.Fields(line)
+		if len(words) > 0 && (words[0] == "G90" || words[0] == "G91") {
+			relative = words[0] == "G91"
+			words = words[1:]
+		}
+		if len(words) < 3 || (words[0] != "G0" && words[0] != "G1") {
+			continue
+		}
+		var nx, ny float64
+		if _, err := fmt.Sscanf(words[1]+" "+words[2], "X%f Y%f", &nx, &ny); err != nil {
+			continue
+		}
+		if relative {
+			nx, ny = x+nx, y+ny
+		}
+		x, y = nx, ny
+		pos = append(pos, [2]float64{x, y})
+	}
+	return pos
+}
+
+func TestRelativeMatchesAbsolute(t *testing.T) {
+	img := squares(80, 80, 20, [2]int{5, 5}, [2]int{50, 40}, [2]int{10, 55})
+	cfg := DefaultConfig()
+	absolute, err := ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Relative = true
+	relative, err := ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(relative, "G91") {
+		t.Fatal("relative program never switches to G91")
+	}
+
+	want, got := positions(absolute), positions(relative)
+	if len(got) != len(want) {
+		t.Fatalf("%d moves in relative mode, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if math.Abs(got[i][0]-want[i][0]) > 1e-6 || math.Abs(got[i][1]-want[i][1]) > 1e-6 {
+			t.Fatalf("move %d reaches %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPauseAtBoundaries(t *testing.T) {
+	img := rect(60, 60, 10, 10, 50, 50)
+	// landmarks lists the comments and pauses of the toolpath in order.
+	landmarks := func(passes, phases bool) string {
+		cfg := DefaultConfig()
+		cfg.Comments, cfg.Passes = true, 2
+		cfg.PausePasses, cfg.PausePhases = passes, phases
+		tp, err := BuildToolpath(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var marks []string
+		for _, m := range tp.Moves {
+			switch m.Type {
+			case MovePause:
+				marks = append(marks, "M0")
+			case MoveComment:
+				marks = append(marks, strings.Fields(m.Text)[0])
+			}
+		}
+		return strings.Join(marks, " ")
+	}
+	for _, tc := range []struct {
+		passes, phases bool
+		want           string
+	}{
+		{false, false, "Path Path Fill"},
+		{true, false, "Path M0 Path Fill"},
+		{false, true, "Path Path M0 Fill"},
+		{true, true, "Path M0 Path M0 Fill"},
+	} {
+		if got := landmarks(tc.passes, tc.phases); got != tc.want {
+			t.Errorf("pause passes %v, phases %v: %s, want %s", tc.passes, tc.phases, got, tc.want)
+		}
+	}
+}
+
+func TestCommentsNumberPathsAndRegions(t *testing.T) {
+	img := squares(80, 80, 20, [2]int{5, 5}, [2]int{50, 40}, [2]int{10, 55})
+	cfg := DefaultConfig()
+	cfg.Comments, cfg.Source = true, "squares.png"
+	gcode, err := ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths, regions []string
+	for _, line := range strings.Split(gcode, "\n") {
+		if m := regexp.MustCompile(`^; Path (\d+) \(\d+ points\)$`).FindStringSubmatch(line); m != nil {
+			paths = append(paths, m[1])
+		}
+		if m := regexp.MustCompile(`^; Fill region (\d+) bbox=`).FindStringSubmatch(line); m != nil {
+			regions = append(regions, m[1])
+		}
+	}
+	if got := strings.Join(paths, " "); got != "1 2 3" {
+		t.Errorf("paths numbered %q, want 1 2 3", got)
+	}
+	if got := strings.Join(regions, " "); got != "1 2 3" {
+		t.Errorf("fill regions numbered %q, want 1 2 3", got)
+	}
+	if !strings.Contains(gcode, "; Source: squares.png\n") || !strings.Contains(gcode, "; Size: ") {
+		t.Error("header comment lacks the source or size")
+	}
+
+	cfg.Comments = false
+	plain, err := ConvertToGCode(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(plain, ";") {
+		t.Error("comments written with Comments off")
+	}
+}
+
+func TestScaleHalvesCoordinates(t *testing.T) {
+	img := squares(80, 80, 20, [2]int{5, 5}, [2]int{50, 40})
+	run := func(scale float64) [][2]float64 {
+		cfg := DefaultConfig()
+		cfg.Scale = scale
+		gcode, err := ConvertToGCode(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return positions(gcode)
+	}
+	full, half := run(1), run(0.5)
+	if len(full) != len(half) {
+		t.Fatalf("%d moves at half scale, want %d", len(half), len(full))
+	}
+	// Both are rounded to the default three decimals.
+	for i := range full {
+		if math.Abs(half[i][0]-full[i][0]/2) > 1e-3 || math.Abs(half[i][1]-full[i][1]/2) > 1e-3 {
+			t.Fatalf("move %d reaches %v at half scale, want half of %v", i, half[i], full[i])
+		}
+	}
+}
+
+func TestCenteredIsSymmetric(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Centered = true
+	tp, err := BuildToolpath(rect(60, 60, 10, 10, 50, 50), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := tp.extents()
+	if e.minX >= 0 || math.Abs(e.minX+e.maxX) > 1e-6 || math.Abs(e.minY+e.maxY) > 1e-6 {
+		t.Errorf("centered square spans X %v to %v, Y %v to %v, want symmetric about 0", e.minX, e.maxX, e.minY, e.maxY)
+	}
+}
+
+func TestSerpentineJoinsScanLines(t *testing.T) {
+	img := rect(60, 60, 10, 10, 50, 50)
+	cfg := DefaultConfig()
+	cfg.Width, cfg.Height = 60, 60
+	cfg.NoFill = true
+	outline, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.NoFill = false
+	plain, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Serpentine = true
+	joined, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fill's own laser-ons, the outline's taken away.
+	lines := countMoves(plain, MoveLaserOn) - countMoves(outline, MoveLaserOn)
+	burns := countMoves(joined, MoveLaserOn) - countMoves(outline, MoveLaserOn)
+	if lines < 5 || burns > lines/4 {
+		t.Errorf("serpentine fill switches the laser on %d times for %d scan lines, want far fewer", burns, lines)
+	}
+}
+
+func TestPrecisionSetsDecimals(t *testing.T) {
+	img := rect(40, 40, 10, 10, 30, 30)
+	for _, places := range []int{0, 1, 5} {
+		cfg := DefaultConfig()
+		cfg.Precision = &places
+		gcode, err := ConvertToGCode(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := coordDecimals(strings.ReplaceAll(gcode, "X0 Y0", "")); len(got) != 1 || !got[places] {
+			t.Errorf("precision %d: coordinates have %v decimal places", places, got)
+		}
+	}
+}
+
+func TestRawOmitsHeaderAndFooter(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Raw = true
+	gcode, err := ConvertToGCode(rect(40, 40, 10, 10, 30, 30), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := strings.SplitN(gcode, "\n", 2)[0]
+	if !regexp.MustCompile(`^(G0 |G1 |M3 |M4 |M5$)`).MatchString(first) {
+		t.Errorf("raw program starts with %q, want a motion or laser command", first)
+	}
+	for _, setup := range []string{"G21", "G90"} {
+		if strings.Contains(gcode, setup) {
+			t.Errorf("raw program contains %s", setup)
+		}
+	}
+	if last := lastLine(gcode); last == "G0 X0 Y0" || last == EndM2 {
+		t.Errorf("raw program ends with %q", last)
+	}
+}
+
+func TestFillInsetKeepsOffOutline(t *testing.T) {
+	img := rect(60, 60, 10, 10, 50, 50)
+	cfg := DefaultConfig()
+	cfg.Width, cfg.Height = 60, 60
+	cfg.Comments, cfg.FillInset = true, 1
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var outline, fill extents
+	filling := false
+	for _, m := range tp.Moves {
+		if m.Type == MoveComment {
+			filling = strings.HasPrefix(m.Text, "Fill region")
+		}
+		if m.Type != MoveCut {
+			continue
+		}
+		if filling {
+			fill.add(m.X, m.Y)
+		} else {
+			outline.add(m.X, m.Y)
+		}
+	}
+	// At a millimetre a pixel, the fill should stop a pixel inside the
+	// outline on every side.
+	if !fill.valid || fill.minX < outline.minX+1 || fill.maxX > outline.maxX-1 || fill.minY < outline.minY+1 || fill.maxY > outline.maxY-1 {
+		t.Errorf("fill spans %+v, want it a pixel inside the outline %+v", fill, outline)
+	}
+}
+
+func TestMaxPathsLimit(t *testing.T) {
+	var dots [][2]int
+	for y := 2; y < 60; y += 8 {
+		for x := 2; x < 60; x += 8 {
+			dots = append(dots, [2]int{x, y})
+		}
+	}
+	img := squares(60, 60, 4, dots...)
+	cfg := DefaultConfig()
+	cfg.MaxPaths = 10
+	if _, err := ConvertToGCode(img, cfg); !errors.Is(err, ErrTooManyPaths) {
+		t.Fatalf("%d dots under a limit of 10: err = %v, want ErrTooManyPaths", len(dots), err)
+	}
+	cfg.MaxPaths = 100
+	if _, err := ConvertToGCode(img, cfg); err != nil {
+		t.Fatalf("%d dots under a limit of 100: %v", len(dots), err)
+	}
+}
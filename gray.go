@@ -0,0 +1,42 @@
+package main
+
+// GrayStrategy reduces a color, as returned by color.Color.RGBA, to an
+// 8-bit gray level where 0 is black (fully engraved) and 255 is white.
+type GrayStrategy interface {
+	Gray(r, g, b, a uint32) uint8
+}
+
+// WeightedGray is a GrayStrategy taking a weighted average of the 8-bit
+// channels. Weights are relative; a zero weight ignores that channel.
+// Weights that do not add up to a positive total, such as the zero value,
+// average nothing, and LumaGray is used instead.
+type WeightedGray struct {
+	R, G, B int
+}
+
+func (w WeightedGray) Gray(r, g, b, a uint32) uint8 {
+	total := w.R + w.G + w.B
+	if total <= 0 {
+		return LumaGray.Gray(r, g, b, a)
+	}
+	sum := w.R*int(uint8(r>>8)) + w.G*int(uint8(g>>8)) + w.B*int(uint8(b>>8))
+	return uint8(sum / total)
+}
+
+// Built-in strategies selectable from the command line.
+var (
+	LumaGray    = WeightedGray{299, 587, 114}
+	AverageGray = WeightedGray{1, 1, 1}
+	RedGray     = WeightedGray{1, 0, 0}
+	GreenGray   = WeightedGray{0, 1, 0}
+	BlueGray    = WeightedGray{0, 0, 1}
+)
+
+// grayStrategies maps the -gray flag values to their strategies.
+var grayStrategies = map[string]GrayStrategy{
+	"luma":    LumaGray,
+	"average": AverageGray,
+	"red":     RedGray,
+	"green":   GreenGray,
+	"blue":    BlueGray,
+}
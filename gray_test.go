@@ -0,0 +1,41 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestGrayStrategies(t *testing.T) {
+	tests := []struct {
+		c                               color.RGBA
+		luma, average, red, green, blue uint8
+	}{
+		{color.RGBA{0, 0, 0, 255}, 0, 0, 0, 0, 0},
+		{color.RGBA{255, 255, 255, 255}, 255, 255, 255, 255, 255},
+		{color.RGBA{255, 0, 0, 255}, 76, 85, 255, 0, 0},
+		{color.RGBA{0, 255, 0, 255}, 149, 85, 0, 255, 0},
+		{color.RGBA{0, 0, 255, 255}, 29, 85, 0, 0, 255},
+		{color.RGBA{200, 100, 50, 255}, 124, 116, 200, 100, 50},
+	}
+	for _, tt := range tests {
+		r, g, b, a := tt.c.RGBA()
+		for name, want := range map[string]uint8{
+			"luma": tt.luma, "average": tt.average, "red": tt.red, "green": tt.green, "blue": tt.blue,
+		} {
+			if got := grayStrategies[name].Gray(r, g, b, a); got != want {
+				t.Errorf("%s of %v = %d, want %d", name, tt.c, got, want)
+			}
+		}
+	}
+}
+
+func TestWeightedGrayWithoutWeights(t *testing.T) {
+	c := color.RGBA{200, 100, 50, 255}
+	r, g, b, a := c.RGBA()
+	want := LumaGray.Gray(r, g, b, a)
+	for _, w := range []WeightedGray{{}, {1, -1, 0}, {-1, 0, 0}} {
+		if got := w.Gray(r, g, b, a); got != want {
+			t.Errorf("%+v of %v = %d, want the luma %d", w, c, got, want)
+		}
+	}
+}
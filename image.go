@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+	"golang.org/x/image/webp"
+)
+
+// inputFormats are the formats DecodeImage handles, by file extension.
+var inputFormats = []string{"svg", "png", "jpg", "jpeg", "bmp", "tif", "tiff", "gif", "webp"}
+
+// SupportedInputFormats returns the image formats this build can convert,
+// as the file extensions DecodeImage accepts.
+func SupportedInputFormats() []string {
+	return slices.Clone(inputFormats)
+}
+
+// isImageFile reports whether path has an extension DecodeImage handles.
+func isImageFile(path string) bool {
+	return slices.Contains(inputFormats, strings.TrimPrefix(strings.ToLower(filepath.Ext(path)), "."))
+}
+
+// LoadImage reads and decodes the image at filePath, picking the decoder
+// from the file extension.
+func LoadImage(filePath string) (image.Image, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return DecodeImage(f, filepath.Ext(filePath))
+}
+
+// LoadImageDPI is LoadImage with SVGs rasterized at svgDPI; see
+// DecodeSVGDPI.
+func LoadImageDPI(filePath string, svgDPI float64) (image.Image, error) {
+	return loadImageAs(filePath, filepath.Ext(filePath), svgDPI, nil)
+}
+
+// loadImageAs is LoadImageDPI decoding the file as format, which need not
+// match its extension, and warning of anything it leaves out on logger;
+// see decodeImage.
+func loadImageAs(filePath, format string, svgDPI float64, logger *log.Logger) (image.Image, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return decodeImage(f, format, svgDPI, logger)
+}
+
+// DecodeImage decodes an image of the given format from r without touching
+// the filesystem. format is a file extension such as "png" or ".svg", in
+// any case.
+func DecodeImage(r io.Reader, format string) (image.Image, error) {
+	return DecodeImageDPI(r, format, 0)
+}
+
+// DecodeImageDPI is DecodeImage with SVGs rasterized at svgDPI; see
+// DecodeSVGDPI.
+func DecodeImageDPI(r io.Reader, format string, svgDPI float64) (image.Image, error) {
+	return decodeImage(r, format, svgDPI, nil)
+}
+
+// decodeImage is DecodeImageDPI warning on logger, when not nil, of
+// anything in the image that is left out, such as the later frames of an
+// animated GIF.
+func decodeImage(r io.Reader, format string, svgDPI float64, logger *log.Logger) (image.Image, error) {
+	switch strings.ToLower(strings.TrimPrefix(format, ".")) {
+	case "svg":
+		return DecodeSVGDPI(r, svgDPI)
+	case "png":
+		return png.Decode(r)
+	case "jpg", "jpeg":
+		return jpeg.Decode(r)
+	case "bmp":
+		return bmp.Decode(r)
+	case "tif", "tiff":
+		return tiff.Decode(r)
+	case "gif":
+		return decodeGIF(r, logger)
+	case "webp":
+		return webp.Decode(r)
+	default:
+		return nil, fmt.Errorf("unsupported image format %q", format)
+	}
+}
+
+// decodeGIF returns the first frame of the GIF read from r. Later frames of
+// an animation are ignored, with a warning on logger when it is not nil.
+func decodeGIF(r io.Reader, logger *log.Logger) (image.Image, error) {
+	g, err := gif.DecodeAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(g.Image) > 1 && logger != nil {
+		logger.Printf("GIF has %d frames; animation is ignored and only the first frame is used", len(g.Image))
+	}
+	return g.Image[0], nil
+}
+
+// backgroundLevel is the default Config.BackgroundThreshold.
+const backgroundLevel = 230
+
+// ProcessImage returns the grayscale working image the extractors see for
+// img under cfg, after every preprocessing step.
+func ProcessImage(img image.Image, cfg Config) *image.Gray {
+	cfg = cfg.withDefaults()
+	gray := toneImage(img, cfg)
+	switch {
+	case cfg.Adaptive != "":
+		gray = adaptiveThreshold(gray, cfg.AdaptiveWindow, cfg.Adaptive == AdaptiveGaussian, cfg.AdaptiveOffset)
+	case cfg.AutoThreshold:
+		gray = foregroundMask(gray, otsuLevel(gray))
+	}
+	if cfg.AutoCrop {
+		gray = cropToContent(gray, cfg.CropMargin, cfg.BackgroundThreshold)
+	}
+	return gray
+}
+
+// toneImage returns img in gray with cfg's noise filters and tone
+// adjustments applied, the levels any threshold is taken from.
+func toneImage(img image.Image, cfg Config) *image.Gray {
+	gray := grayscaleImage(img, cfg.Gray, cfg.Invert, cfg.AlphaThreshold)
+	gray = medianFilter(gray, cfg.Denoise)
+	gray = gaussianBlur(gray, cfg.Blur)
+	adjustTone(gray, cfg)
+	return gray
+}
+
+// AutoThreshold returns the background threshold Otsu's method picks for
+// img under cfg, the one Config.AutoThreshold splits the image at.
+func AutoThreshold(img image.Image, cfg Config) uint8 {
+	return otsuLevel(toneImage(img, cfg.withDefaults()))
+}
+
+// adjustTone remaps every level of img in place with cfg's tone settings,
+// in this order: Brightness is added, Contrast stretches the result around
+// mid-gray (128) and is clamped to 0..255, then Gamma applies
+// 255 * (level/255)^(1/Gamma). A gamma above 1 lightens the midtones and
+// shadows, below 1 darkens them; black and white stay put.
+func adjustTone(img *image.Gray, cfg Config) {
+	contrast, gamma := cfg.Contrast, cfg.Gamma
+	if contrast == 0 {
+		contrast = 1
+	}
+	if gamma <= 0 {
+		gamma = 1
+	}
+	if cfg.Brightness == 0 && contrast == 1 && gamma == 1 {
+		return
+	}
+
+	var curve [256]uint8
+	for i := range curve {
+		v := (float64(i+cfg.Brightness)-128)*contrast + 128
+		v = math.Max(0, math.Min(255, v))
+		curve[i] = uint8(math.Round(255 * math.Pow(v/255, 1/gamma)))
+	}
+	for i, v := range img.Pix {
+		img.Pix[i] = curve[v]
+	}
+}
+
+// cropToContent returns img cut down to the bounding box of its foreground
+// pixels, those darker than background, plus margin pixels of white on every
+// side, so scaling fits the design rather than the canvas. An image with no
+// foreground is returned unchanged.
+func cropToContent(img *image.Gray, margin int, background uint8) *image.Gray {
+	b := img.Bounds()
+	minX, minY, maxX, maxY := b.Max.X, b.Max.Y, b.Min.X-1, b.Min.Y-1
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.GrayAt(x, y).Y >= background {
+				continue
+			}
+			minX, maxX = min(minX, x), max(maxX, x)
+			minY, maxY = min(minY, y), max(maxY, y)
+		}
+	}
+	if maxX < minX {
+		return img
+	}
+
+	w, h := maxX-minX+1+2*margin, maxY-minY+1+2*margin
+	out := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range out.Pix {
+		out.Pix[i] = 255
+	}
+	for y := minY; y <= maxY; y++ {
+		src := img.Pix[img.PixOffset(minX, y) : img.PixOffset(maxX, y)+1]
+		copy(out.Pix[out.PixOffset(margin, y-minY+margin):], src)
+	}
+	return out
+}
+
+// grayscaleImage converts img into the 8-bit working image the extractors
+// run on, using strategy (luma when nil). With invert set the result is the
+// negative, so light areas are engraved; inversion happens before any
+// threshold is applied.
+//
+// Pixels less opaque than alphaThreshold (0-255), and fully transparent
+// ones whatever it is, are background: white, inverted or not. The rest are
+// composited over white first, so a half-transparent black comes out mid
+// gray.
+func grayscaleImage(img image.Image, strategy GrayStrategy, invert bool, alphaThreshold uint8) *image.Gray {
+	if strategy == nil {
+		strategy = LumaGray
+	}
+
+	bounds := img.Bounds()
+	out := image.NewGray(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			if a == 0 || uint8(a>>8) < alphaThreshold {
+				out.Pix[y*out.Stride+x] = 255
+				continue
+			}
+			// The channels come premultiplied; white shows through
+			// wherever the pixel lets it.
+			gray := strategy.Gray(r+0xffff-a, g+0xffff-a, b+0xffff-a, 0xffff)
+			if invert {
+				gray = 255 - gray
+			}
+			out.Pix[y*out.Stride+x] = gray
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+func TestDecodeImageBMPAndTIFF(t *testing.T) {
+	src := rect(4, 3, 1, 1, 3, 2)
+	encoders := map[string]func(io.Writer, image.Image) error{
+		"bmp":  bmp.Encode,
+		"tif":  func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) },
+		"tiff": func(w io.Writer, m image.Image) error { return tiff.Encode(w, m, nil) },
+	}
+	for format, encode := range encoders {
+		var buf bytes.Buffer
+		if err := encode(&buf, src); err != nil {
+			t.Fatal(err)
+		}
+		img, err := DecodeImage(&buf, "."+format)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if img == nil || img.Bounds().Dx() != 4 || img.Bounds().Dy() != 3 {
+			t.Errorf("%s: decoded %v, want a 4×3 image", format, img)
+		}
+	}
+}
+
+// darkPixels counts the pixels of gray below the background level.
+func darkPixels(gray *image.Gray, background uint8) int {
+	n := 0
+	for _, v := range gray.Pix {
+		if v < background {
+			n++
+		}
+	}
+	return n
+}
+
+func TestProcessImageInvert(t *testing.T) {
+	// Black but for a white square in the middle.
+	img := rect(40, 40, 0, 0, 40, 40)
+	for y := 15; y < 25; y++ {
+		for x := 15; x < 25; x++ {
+			img.Pix[y*img.Stride+x] = 255
+		}
+	}
+	cfg := DefaultConfig()
+	total := 40 * 40
+
+	if n := darkPixels(ProcessImage(img, cfg), cfg.BackgroundThreshold); n != total-100 {
+		t.Errorf("plain: %d of %d pixels engraved, want %d", n, total, total-100)
+	}
+	cfg.Invert = true
+	if n := darkPixels(ProcessImage(img, cfg), cfg.BackgroundThreshold); n != 100 {
+		t.Errorf("inverted: %d of %d pixels engraved, want only the 100 of the square", n, total)
+	}
+}
+
+// ramp returns a 256×1 image holding every gray level once, in order.
+func ramp() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 256, 1))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	return img
+}
+
+func TestAdjustToneIsContinuous(t *testing.T) {
+	for _, tc := range []struct {
+		brightness int
+		contrast   float64
+		maxStep    int
+	}{
+		{0, 1, 1},
+		{40, 1, 1},
+		{-40, 1.5, 2},
+		{0, 0.5, 1},
+	} {
+		img := ramp()
+		cfg := DefaultConfig()
+		cfg.Brightness, cfg.Contrast = tc.brightness, tc.contrast
+		adjustTone(img, cfg)
+		for i := 1; i < len(img.Pix); i++ {
+			if step := int(img.Pix[i]) - int(img.Pix[i-1]); step < 0 || step > tc.maxStep {
+				t.Errorf("brightness %d, contrast %v: level %d maps %d past level %d", tc.brightness, tc.contrast, i, step, i-1)
+				break
+			}
+		}
+	}
+}
+
+func TestAdjustToneGamma(t *testing.T) {
+	level := func(gamma float64) uint8 {
+		img := ramp()
+		cfg := DefaultConfig()
+		cfg.Gamma = gamma
+		adjustTone(img, cfg)
+		return img.Pix[128]
+	}
+	if flat, lifted := level(1), level(2); lifted <= flat {
+		t.Errorf("gamma 2 maps mid-gray to %d, want lighter than gamma 1's %d", lifted, flat)
+	}
+}
+
+func TestAdjustToneContrast(t *testing.T) {
+	img := ramp()
+	cfg := DefaultConfig()
+	cfg.Contrast = 2
+	adjustTone(img, cfg)
+	if light := img.Pix[180]; light <= 180 {
+		t.Errorf("contrast 2 maps light level 180 to %d, want lighter", light)
+	}
+	if dark := img.Pix[80]; dark >= 80 {
+		t.Errorf("contrast 2 maps dark level 80 to %d, want darker", dark)
+	}
+}
+
+func TestCropToContent(t *testing.T) {
+	img := rect(200, 150, 90, 60, 110, 85)
+	cropped := cropToContent(img, 0, 128)
+	if w, h := cropped.Bounds().Dx(), cropped.Bounds().Dy(); w != 20 || h != 25 {
+		t.Errorf("cropped to %d×%d, want the square's 20×25", w, h)
+	}
+	if n := darkPixels(cropped, 128); n != 20*25 {
+		t.Errorf("cropped image has %d dark pixels, want all %d of the square", n, 20*25)
+	}
+
+	margined := cropToContent(img, 3, 128)
+	if w, h := margined.Bounds().Dx(), margined.Bounds().Dy(); w != 26 || h != 31 {
+		t.Errorf("cropped with a margin of 3 to %d×%d, want 26×31", w, h)
+	}
+	if v := margined.GrayAt(2, 2).Y; v != 255 {
+		t.Errorf("margin pixel is %d, want white", v)
+	}
+
+	blank := rect(50, 50, 0, 0, 0, 0)
+	if got := cropToContent(blank, 0, 128); got.Bounds() != blank.Bounds() {
+		t.Errorf("blank image cropped to %v, want it unchanged", got.Bounds())
+	}
+}
+
+func TestDecodeImageMatchesLoadImage(t *testing.T) {
+	src := squares(60, 60, 20, [2]int{5, 5}, [2]int{30, 35})
+	path := testPNG(t, t.TempDir(), "in.png", src)
+
+	fromFile, err := LoadImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(readFile(t, path))
+	fromMemory, err := DecodeImage(bytes.NewReader(data), "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ConvertToGCode(fromFile, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ConvertToGCode(fromMemory, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Error("G-code from the in-memory PNG differs from the file's")
+	}
+}
+
+func TestDecodeImageGIF(t *testing.T) {
+	src := rect(8, 6, 2, 1, 6, 4)
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, src, nil); err != nil {
+		t.Fatal(err)
+	}
+	img, err := DecodeImage(&buf, "GIF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gray := ProcessImage(img, DefaultConfig())
+	if gray == nil || gray.Bounds().Dx() != 8 || gray.Bounds().Dy() != 6 {
+		t.Fatalf("decoded to %v, want an 8×6 gray image", gray)
+	}
+	if n := darkPixels(gray, backgroundLevel); n != 4*3 {
+		t.Errorf("%d dark pixels, want the 12 of the square", n)
+	}
+}
+
+func TestDecodeAnimatedGIFWarns(t *testing.T) {
+	frame := func() *image.Paletted {
+		return image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	}
+	var buf bytes.Buffer
+	anim := &gif.GIF{Image: []*image.Paletted{frame(), frame(), frame()}, Delay: []int{10, 10, 10}}
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings bytes.Buffer
+	img, err := decodeImage(bytes.NewReader(buf.Bytes()), "gif", 0, log.New(&warnings, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 4, 4) {
+		t.Errorf("decoded %v, want the 4×4 first frame", img.Bounds())
+	}
+	if !strings.Contains(warnings.String(), "3 frames") {
+		t.Errorf("warning %q, want one about the 3 frames", warnings.String())
+	}
+
+	// Decoding without a logger stays silent, not falling back on the
+	// standard logger.
+	var global bytes.Buffer
+	log.SetOutput(&global)
+	defer log.SetOutput(os.Stderr)
+	if _, err := DecodeImage(bytes.NewReader(buf.Bytes()), "gif"); err != nil {
+		t.Fatal(err)
+	}
+	if global.Len() > 0 {
+		t.Errorf("DecodeImage logged %q", global.String())
+	}
+}
+
+func TestAlphaThresholdDecidesEngraving(t *testing.T) {
+	// A black logo whose four columns fade from opaque to transparent.
+	logo := image.NewNRGBA(image.Rect(0, 0, 4, 1))
+	for x, alpha := range []uint8{255, 200, 100, 0} {
+		logo.SetNRGBA(x, 0, color.NRGBA{A: alpha})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, logo); err != nil {
+		t.Fatal(err)
+	}
+	img, err := DecodeImage(&buf, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		threshold uint8
+		engraved  []bool
+	}{
+		{128, []bool{true, true, false, false}},
+		{50, []bool{true, true, true, false}},
+		{255, []bool{true, false, false, false}},
+	} {
+		cfg := DefaultConfig()
+		cfg.AlphaThreshold = tc.threshold
+		gray := ProcessImage(img, cfg)
+		for x, want := range tc.engraved {
+			if got := gray.GrayAt(x, 0).Y < cfg.BackgroundThreshold; got != want {
+				t.Errorf("alpha threshold %d: pixel %d engraved = %v, want %v", tc.threshold, x, got, want)
+			}
+		}
+	}
+}
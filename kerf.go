@@ -0,0 +1,71 @@
+package main
+
+// offsetForKerf moves every closed contour, given in pixels, half of kerf
+// (in output units) away from the material it cuts out, so parts come out
+// true to size after the beam has taken its width: outer boundaries grow and
+// holes shrink. A contour counts as closed when it ends within gap pixels of
+// its start, as for Config.CloseLoops, and as a hole when an odd number of
+// the other closed contours surround it. Open contours are left as they are.
+//
+// The offset is insetPolygon's, exact for convex outlines. Concave corners
+// are mitred, and offsetting can neither merge nor split outlines, so
+// contours closer together than the kerf may come out overlapping. A hole
+// too small to survive the offset is left unchanged.
+func offsetForKerf(contours [][]vec, kerf, gap, scaleX, scaleY float64) [][]vec {
+	loops := make([][]vec, len(contours))
+	for i, c := range contours {
+		if c = closeLoop(c, gap, true); len(c) >= 4 && c[0] == c[len(c)-1] {
+			loops[i] = c
+		}
+	}
+
+	offset := make([][]vec, len(contours))
+	for i, c := range loops {
+		offset[i] = contours[i]
+		if c == nil {
+			continue
+		}
+
+		depth := 0
+		for j, other := range loops {
+			if j != i && other != nil && pointInPolygon(c[0], other) {
+				depth++
+			}
+		}
+		// insetPolygon moves inwards for a positive distance.
+		d := -kerf / 2
+		if depth%2 == 1 {
+			d = kerf / 2
+		}
+
+		// Offset in output units so the distance holds whatever the
+		// aspect ratio of the pixels.
+		poly := make([]vec, len(c)-1)
+		for k, p := range c[:len(c)-1] {
+			poly[k] = vec{p.x * scaleX, p.y * scaleY}
+		}
+		moved := insetPolygon(poly, d)
+		if len(moved) < 3 {
+			continue
+		}
+		out := make([]vec, 0, len(moved)+1)
+		for _, p := range moved {
+			out = append(out, vec{p.x / scaleX, p.y / scaleY})
+		}
+		offset[i] = append(out, out[0])
+	}
+	return offset
+}
+
+// pointInPolygon reports whether p lies inside the closed polygon poly by
+// the even-odd rule. A repeated closing vertex does no harm.
+func pointInPolygon(p vec, poly []vec) bool {
+	inside := false
+	for i, a := range poly {
+		b := poly[(i+1)%len(poly)]
+		if (a.y > p.y) != (b.y > p.y) && p.x < a.x+(p.y-a.y)*(b.x-a.x)/(b.y-a.y) {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestKerfExpandsSquare(t *testing.T) {
+	// A 10-pixel square at 0.5mm a pixel with a 4-pixel hole in it. Half
+	// the 0.4mm kerf is 0.4 pixels.
+	outer := []vec{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	hole := []vec{{3, 3}, {7, 3}, {7, 7}, {3, 7}, {3, 3}}
+	offset := offsetForKerf([][]vec{outer, hole}, 0.4, defaultCloseGap, 0.5, 0.5)
+
+	for i, tc := range []struct{ min, max float64 }{
+		{-0.4, 10.4},
+		{3.4, 6.6},
+	} {
+		var e extents
+		for _, p := range offset[i] {
+			e.add(p.x, p.y)
+		}
+		for _, got := range [][2]float64{{e.minX, tc.min}, {e.minY, tc.min}, {e.maxX, tc.max}, {e.maxY, tc.max}} {
+			if math.Abs(got[0]-got[1]) > 1e-9 {
+				t.Errorf("contour %d spans %v,%v to %v,%v, want %v to %v on both axes", i, e.minX, e.minY, e.maxX, e.maxY, tc.min, tc.max)
+				break
+			}
+		}
+	}
+}
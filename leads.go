@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"slices"
+)
+
+// rampSteps is how many moves a feed ramp is split into, each at the feed
+// the ramp reaches halfway along it.
+const rampSteps = 4
+
+// feedRamp speeds up the ends of each outline cut: the first in and last out
+// units of length are cut at feeds running from feed at the end of the path
+// to the cutting feed cut, so the head never lingers where the path starts
+// and stops. The zero value leaves paths alone.
+type feedRamp struct {
+	in, out   float64
+	feed, cut float64
+}
+
+// newFeedRamp returns the ramps cfg asks for, into and out of its cutting
+// feed; LeadFeed 0 starts them at twice that.
+func newFeedRamp(cfg Config) feedRamp {
+	r := feedRamp{in: cfg.LeadIn, out: cfg.LeadOut, feed: cfg.LeadFeed, cut: cfg.CutFeed}
+	if r.feed == 0 {
+		r.feed = 2 * cfg.CutFeed
+	}
+	return r
+}
+
+// rampCuts returns the moves cutting along pts from pts[0], with the ramps
+// of r at either end. The body between the ramps is left at the cutting
+// feed and turned into moves by body, which is handed each run of points to
+// cut through, starting at the current position.
+func rampCuts(pts []vec, r feedRamp, body func(run []vec) []Move) []Move {
+	// dist[i] is how far along the path pts[i] lies.
+	dist := make([]float64, len(pts))
+	for i := 1; i < len(pts); i++ {
+		dist[i] = dist[i-1] + math.Hypot(pts[i].x-pts[i-1].x, pts[i].y-pts[i-1].y)
+	}
+	total := dist[len(dist)-1]
+	if total == 0 || (r.in <= 0 && r.out <= 0) {
+		return body(pts)
+	}
+
+	// On a path too short for both ramps they share it.
+	in, out := math.Min(r.in, total), math.Min(r.out, total)
+	if in+out > total {
+		in, out = total*in/(in+out), total*out/(in+out)
+	}
+
+	// Cut at every point of the path and every step of the ramps.
+	stations := slices.Clone(dist)
+	for i := 1; i <= rampSteps; i++ {
+		stations = append(stations, in*float64(i)/rampSteps, total-out*float64(i-1)/rampSteps)
+	}
+	slices.Sort(stations)
+	stations = slices.Compact(stations)
+
+	pointAt := func(s float64) vec {
+		i, _ := slices.BinarySearch(dist, s)
+		if i == 0 {
+			return pts[0]
+		}
+		if i >= len(pts) {
+			return pts[len(pts)-1]
+		}
+		f := (s - dist[i-1]) / (dist[i] - dist[i-1])
+		return vec{pts[i-1].x + (pts[i].x-pts[i-1].x)*f, pts[i-1].y + (pts[i].y-pts[i-1].y)*f}
+	}
+	// feedAt returns the ramp's feed at s, or 0 in the body.
+	feedAt := func(s float64) float64 {
+		switch {
+		case s < in:
+			return r.feed + (r.cut-r.feed)*s/in
+		case s > total-out:
+			return r.cut + (r.feed-r.cut)*(s-(total-out))/out
+		}
+		return 0
+	}
+
+	var moves []Move
+	var run []vec
+	flush := func() {
+		if len(run) > 1 {
+			moves = append(moves, body(run)...)
+		}
+		run = nil
+	}
+	for i := 1; i < len(stations); i++ {
+		from, to := stations[i-1], stations[i]
+		feed := feedAt((from + to) / 2)
+		if feed == 0 {
+			if run == nil {
+				run = []vec{pointAt(from)}
+			}
+			run = append(run, pointAt(to))
+			continue
+		}
+		flush()
+		p := pointAt(to)
+		moves = append(moves, Move{Type: MoveCut, X: p.x, Y: p.y, Feed: feed})
+	}
+	flush()
+	return moves
+}
@@ -0,0 +1,31 @@
+package main
+
+import "testing"
+
+func TestRampCutsLeadInFaster(t *testing.T) {
+	pts := []vec{{0, 0}, {10, 0}, {20, 0}}
+	r := feedRamp{in: 4, out: 4, feed: 2000, cut: 1000}
+	moves := rampCuts(pts, r, func(run []vec) []Move {
+		var body []Move
+		for _, p := range run[1:] {
+			body = append(body, Move{Type: MoveCut, X: p.x, Y: p.y})
+		}
+		return body
+	})
+
+	// The lead-in slows from the ramp feed towards the cutting feed.
+	for i, m := range moves[:rampSteps] {
+		if m.Feed <= r.cut || m.Feed >= r.feed {
+			t.Errorf("lead-in move %d at F%v, want between the cutting F%v and F%v", i, m.Feed, r.cut, r.feed)
+		}
+		if i > 0 && m.Feed >= moves[i-1].Feed {
+			t.Errorf("lead-in move %d at F%v, want slower than F%v before it", i, m.Feed, moves[i-1].Feed)
+		}
+	}
+	if body := moves[rampSteps]; body.Feed != 0 {
+		t.Errorf("first body move at F%v, want the cutting feed", body.Feed)
+	}
+	if last := moves[len(moves)-1]; last.Feed <= r.cut || last.X != 20 {
+		t.Errorf("path ends with %+v, want a lead-out faster than the cut ending at X20", last)
+	}
+}
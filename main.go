@@ -1,39 +1,884 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"image"
+	"image/png"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Exit codes reported for each stage of the pipeline, so scripts can tell
+// which step failed without parsing the error message.
+const (
+	exitOK      = 0
+	exitUsage   = 1
+	exitLoad    = 2
+	exitConvert = 3
+	exitWrite   = 4
 )
 
 func main() {
-	inputFile := flag.String("input", "", "Path to the input SVG file")
-	outputFile := flag.String("output", "output.gcode", "Path to output G-code file")
-	width := flag.Float64("width", 100.0, "Target engraving width (mm)")
-	height := flag.Float64("height", 100.0, "Target engraving height (mm)")
-	offset := flag.Float64("offset", 0.0, "Offset (mm) to apply to both X and Y")
-	threshold := flag.Uint("threshold", 128, "Grayscale threshold for engraving (0-255)")
-	flag.Parse()
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run parses args, converts the input and writes the G-code, returning the
+// process exit code. It never calls os.Exit so it can be driven from tests.
+// An input of "-" is read from stdin and an output of "-" is written to
+// stdout, in which case the messages stdout normally gets go to stderr.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	logger := log.New(stderr, "", log.LstdFlags)
+
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	inputFile := fs.String("input", "", "Path to the input image (svg, png, jpg, bmp, tif), a directory or glob of them, or - for stdin")
+	inputFormat := fs.String("informat", "", "Format of the input image (e.g. png); required with -input -, otherwise overrides the file extension")
+	outputFile := fs.String("output", "output.gcode", "Path to output G-code file, or - for stdout")
+	outputDir := fs.String("outputdir", ".", "Directory for the G-code files when -input is a directory or glob")
+	outputFormat := fs.String("output-format", "gcode", "Output format: gcode, json (intermediate toolpath) or dxf (outline polylines)")
+	fs.StringVar(outputFormat, "format", *outputFormat, "Alias for -output-format")
+	validate := fs.Bool("validate", false, "Run the conversion and report the job without writing any files; exits non-zero if it fails")
+	preview := fs.String("preview", "", "Also render the toolpath to this SVG file, cuts in black and travel in gray")
+	dumpProcessed := fs.String("dump-processed", "", "Also write the processed grayscale image the extractors see to this PNG file")
+	headerFile := fs.String("header", "", "File whose contents replace the built-in G-code header ({WIDTH}, {HEIGHT}, {FEED}, {TRAVELFEED}, {POWER} are substituted)")
+	footerFile := fs.String("footer", "", "File whose contents replace the built-in return and end command (same tokens as -header)")
+	cpuProfile := fs.String("cpuprofile", "", "Write a CPU profile of the conversion to this file")
+	memProfile := fs.String("memprofile", "", "Write a heap profile taken after the conversion to this file")
+	svgDPI := fs.Float64("svgdpi", 0, "Rasterize SVGs at this resolution (pixels per inch of their declared size) instead of one pixel per viewBox unit")
+	grayMode := fs.String("gray", "luma", "Color to gray conversion: luma, average, red, green or blue")
+	configFile := fs.String("config", "", "JSON machine profile to start from; flags given on the command line override it")
+	dumpConfig := fs.String("dumpconfig", "", "Write the effective settings as a JSON profile to this file")
+	showVersion := fs.Bool("version", false, "Print the version and the supported input and output formats, then exit")
+
+	cfg := DefaultConfig()
+	fs.StringVar(&cfg.Units, "units", cfg.Units, "Units for sizes, offsets, spacing and feeds: mm or in")
+	fs.Float64Var(&cfg.Width, "width", cfg.Width, "Target engraving width (mm)")
+	fs.Float64Var(&cfg.Height, "height", cfg.Height, "Target engraving height (mm)")
+	fs.Float64Var(&cfg.DPI, "dpi", cfg.DPI, "Engrave the image at its true size for this resolution (pixels per inch) instead of -width/-height")
+	fs.Float64Var(&cfg.Scale, "scale", cfg.Scale, "Factor to scale the design by after sizing it, e.g. 0.8 for 80%")
+	offset := fs.Float64("offset", 0, "Offset (mm) to apply to both X and Y unless -offsetx/-offsety are given")
+	fs.Float64Var(&cfg.OffsetX, "offsetx", cfg.OffsetX, "X offset (mm); overrides -offset")
+	fs.Float64Var(&cfg.OffsetY, "offsety", cfg.OffsetY, "Y offset (mm); overrides -offset")
+	fs.Float64Var(&cfg.BedWidth, "bedwidth", cfg.BedWidth, "Machine bed width (mm); jobs moving outside it fail unless -clip is given. 0 means no limit")
+	fs.Float64Var(&cfg.BedHeight, "bedheight", cfg.BedHeight, "Machine bed height (mm); jobs moving outside it fail unless -clip is given. 0 means no limit")
+	fs.Float64Var(&cfg.TravelSafe, "travelsafe", cfg.TravelSafe, "Switch the laser off (or retract the cutter) for any rapid longer than this (mm) made with it on; 0 disables")
+	fs.BoolVar(&cfg.ClipToBed, "clip", cfg.ClipToBed, "Leave out moves beyond -bedwidth/-bedheight instead of failing the job")
+	returnTo := fs.String("returnto", "origin", "Where to park after the job: origin or offset")
+	precision := fs.Int("precision", -1, "Decimal places (0-6) for coordinates; default 3 for mm, 4 for inches")
+	pauseAt := fs.String("pauseat", "", "Comma-separated boundaries to pause (M0) at: passes, between passes, and phases, between outlines and fill")
+	threshold := fs.Uint("threshold", uint(cfg.Threshold), "Deprecated and ignored; use -bgthreshold")
+	alphaThreshold := fs.Uint("alphathreshold", uint(cfg.AlphaThreshold), "Opacity (0-255) below which transparent pixels are background and never engraved; more opaque ones are blended onto white")
+	bgThreshold := fs.Uint("bgthreshold", uint(cfg.BackgroundThreshold), "Gray level (1-255) at and above which pixels are background; darker pixels are traced and filled")
+	fs.IntVar(&cfg.Supersample, "supersample", cfg.Supersample, "Trace outlines on the image enlarged this many times (2-4) for smoother slanted edges; 0 traces at native resolution")
+	fs.StringVar(&cfg.EdgeDetect, "edgedetect", cfg.EdgeDetect, "Outline edge detection: neighbor (foreground against background) or sobel (any steep tonal change)")
+	fs.IntVar(&cfg.EdgeThreshold, "edgethreshold", cfg.EdgeThreshold, "Gradient strength (0-255) that counts as an edge with -edgedetect sobel")
+	fs.BoolVar(&cfg.Invert, "invert", cfg.Invert, "Invert the image so light areas are engraved instead of dark ones")
+	fs.IntVar(&cfg.Denoise, "denoise", cfg.Denoise, "Median filter kernel size (e.g. 3) to remove speckle before tracing; 0 disables")
+	fs.Float64Var(&cfg.Blur, "blur", cfg.Blur, "Gaussian blur sigma (pixels) applied before tracing; 0 disables")
+	fs.BoolVar(&cfg.AutoThreshold, "autothreshold", cfg.AutoThreshold, "Pick the background threshold from the image's histogram (Otsu) instead of -bgthreshold")
+	fs.StringVar(&cfg.CutColor, "cutcolor", cfg.CutColor, "Cut outlines only around parts in this colour (#rrggbb), e.g. #ff0000; with -engravecolor, everything else is background")
+	fs.StringVar(&cfg.EngraveColor, "engravecolor", cfg.EngraveColor, "Fill only the parts in this colour (#rrggbb), e.g. #000000; with -cutcolor, everything else is background")
+	fs.Float64Var(&cfg.ColorTolerance, "colortolerance", cfg.ColorTolerance, "How far (RGB distance, 0-442) a pixel may be from -cutcolor or -engravecolor and still match")
+	fs.StringVar(&cfg.Adaptive, "adaptive", cfg.Adaptive, "Threshold each pixel against its neighbourhood for unevenly lit scans: mean or gaussian")
+	fs.IntVar(&cfg.AdaptiveWindow, "adaptivewindow", cfg.AdaptiveWindow, "Side (pixels, odd) of the neighbourhood -adaptive averages")
+	fs.Float64Var(&cfg.AdaptiveOffset, "adaptiveoffset", cfg.AdaptiveOffset, "Levels below the neighbourhood mean a pixel must be for -adaptive to engrave it")
+	fs.IntVar(&cfg.Brightness, "brightness", cfg.Brightness, "Brightness added to the grayscale image (-255..255), before -contrast and -gamma")
+	fs.Float64Var(&cfg.Contrast, "contrast", cfg.Contrast, "Contrast gain around mid-gray; above 1 pushes tones apart, below 1 flattens them")
+	fs.Float64Var(&cfg.Gamma, "gamma", cfg.Gamma, "Gamma correction of the grayscale image; above 1 lifts shadows, below 1 deepens them")
+	fs.Float64Var(&cfg.TravelFeed, "travelfeed", cfg.TravelFeed, "Travel feed rate (mm/min)")
+	fs.Float64Var(&cfg.CutFeed, "feed", cfg.CutFeed, "Cutting feed rate (mm/min)")
+	fs.Float64Var(&cfg.LeadIn, "leadin", cfg.LeadIn, "Length (mm) at the start of each outline cut over which the feed ramps down from -leadfeed")
+	fs.Float64Var(&cfg.LeadOut, "leadout", cfg.LeadOut, "Length (mm) at the end of each outline cut over which the feed ramps back up to -leadfeed")
+	fs.Float64Var(&cfg.LeadFeed, "leadfeed", cfg.LeadFeed, "Feed rate (mm/min) at the ends of the lead-in and lead-out ramps; 0 is twice -feed")
+	fs.Float64Var(&cfg.Accel, "accel", cfg.Accel, "Machine acceleration (mm/s²) to account for in the job time estimate; 0 ignores it")
+	fs.IntVar(&cfg.Power, "power", cfg.Power, "Laser power (S value) while burning")
+	fs.IntVar(&cfg.Dwell, "dwell", cfg.Dwell, "Pause (ms) after each laser-on so a CO2 tube can stabilise; emitted as G4 in seconds")
+	fs.BoolVar(&cfg.AirAssist, "airassist", cfg.AirAssist, "Switch air assist on for the whole job")
+	fs.StringVar(&cfg.AirAssistCommand, "aircmd", cfg.AirAssistCommand, "Command that switches air assist on: M8 or M7")
+	fs.BoolVar(&cfg.Relative, "relative", cfg.Relative, "Write moves as relative steps (G91) after a first absolute move")
+	fs.BoolVar(&cfg.Raw, "raw", cfg.Raw, "Write only the moves and laser commands, without the program header, footer and return to origin, for embedding in another program")
+	fs.BoolVar(&cfg.Comments, "comments", cfg.Comments, "Annotate the G-code with ';' comments naming the source, settings and every outline and fill region")
+	fs.BoolVar(&cfg.Home, "home", cfg.Home, "Start the program with a GRBL homing cycle ($H)")
+	fs.BoolVar(&cfg.SetZero, "setzero", cfg.SetZero, "Make the head's position the work origin (G10 L20 P1 X0 Y0) before the job, after -home if given")
+	fs.StringVar(&cfg.EndCommand, "endcmd", cfg.EndCommand, "Program end command: M2, M30 or none")
+	fs.StringVar(&cfg.LaserMode, "lasermode", cfg.LaserMode, "Laser power mode: constant (M3) or dynamic (M4)")
+	fs.BoolVar(&cfg.FromContent, "origin-offset-from-content", cfg.FromContent, "Place the content's bounding-box corner at the origin plus offset, ignoring surrounding whitespace")
+	fs.BoolVar(&cfg.Centered, "centered", cfg.Centered, "Centre the content's bounding box on the origin plus offset, for a work zero in the middle of the material")
+	fs.BoolVar(&cfg.Frame, "frame", cfg.Frame, "Trace the job's bounding box with the laser off instead of engraving")
+	fs.BoolVar(&cfg.Optimize, "optimize", cfg.Optimize, "Reorder outline paths and fill regions to minimise travel between them")
+	fs.Float64Var(&cfg.SpotSize, "spot-size", cfg.SpotSize, "Laser spot diameter (mm); sets the fill line spacing so scan lines just touch")
+	fs.BoolVar(&cfg.Crosshatch, "crosshatch", cfg.Crosshatch, "Add a vertical fill pass on top of the horizontal one")
+	fs.Float64Var(&cfg.FillAngle, "fillangle", cfg.FillAngle, "Angle (degrees) of the zigzag fill lines from the X axis; 90 scans vertically")
+	fs.Float64Var(&cfg.MinSegment, "minsegment", cfg.MinSegment, "Shortest fill line (mm) to burn; 0 skips lines under 3 pixels")
+	fs.Float64Var(&cfg.Overscan, "overscan", cfg.Overscan, "Distance (mm) to run on with the laser off before and after each fill line so it burns at full speed")
+	fs.BoolVar(&cfg.Serpentine, "serpentine", cfg.Serpentine, "Join consecutive zigzag fill lines of a solid area with the laser on instead of switching it off between them; ignored with -overscan")
+	fs.BoolVar(&cfg.Stipple, "stipple", cfg.Stipple, "Engrave the image as dots, denser in darker areas, instead of outlines and fills")
+	fs.Float64Var(&cfg.StippleSpacing, "stipplespacing", cfg.StippleSpacing, "Grid pitch (mm) stipple dots are scattered on, at most one per cell; 0 uses the fill line spacing")
+	fs.IntVar(&cfg.StippleDwell, "stippledwell", cfg.StippleDwell, "How long (ms) each stipple dot burns; 0 uses 20")
+	fs.Int64Var(&cfg.Seed, "seed", cfg.Seed, "Seed for where stipple dots fall and how far -pass-angle-jitter turns each fill pass; the same seed always gives the same job")
+	fs.StringVar(&cfg.FillMode, "fillmode", cfg.FillMode, "Fill pattern: zigzag scan lines or concentric rings following the outline")
+	fs.Float64Var(&cfg.LineSpacing, "linespacing", cfg.LineSpacing, "Distance between fill scan lines (mm); overrides -spot-size")
+	fs.IntVar(&cfg.Passes, "passes", cfg.Passes, "Number of times to cut the outlines")
+	fs.Float64Var(&cfg.PassDepth, "passdepth", cfg.PassDepth, "Z step down (mm) before each outline pass after the first; 0 emits no Z moves")
+	fs.IntVar(&cfg.FillPasses, "fillpasses", cfg.FillPasses, "Number of times to engrave the fill")
+	fs.Float64Var(&cfg.PassAngleJitter, "pass-angle-jitter", cfg.PassAngleJitter, "Turn each fill pass's zigzag lines by a random angle up to this many degrees either side of -fillangle, drawn from -seed")
+	fs.BoolVar(&cfg.CNC, "cnc", cfg.CNC, "CNC mode: plunge and retract Z instead of switching a laser")
+	fs.Float64Var(&cfg.CutDepth, "cutdepth", cfg.CutDepth, "CNC cutting depth below Z0 (mm)")
+	fs.Float64Var(&cfg.SafeZ, "safez", cfg.SafeZ, "CNC travel height above Z0 (mm)")
+	fs.Float64Var(&cfg.PlungeFeed, "plungefeed", cfg.PlungeFeed, "CNC plunge feed rate (mm/min)")
+	fs.Float64Var(&cfg.MinFillArea, "minfill", cfg.MinFillArea, "Smallest region (mm²) to fill; 0 fills regions of 200 pixels or more")
+	fs.IntVar(&cfg.MaxPaths, "maxpaths", cfg.MaxPaths, "Fail rather than write a job with more outlines, or fill regions, than this; 0 for no limit")
+	fs.IntVar(&cfg.MinPathPoints, "minpath", cfg.MinPathPoints, "Fewest traced points an outline needs to be cut")
+	fs.IntVar(&cfg.FillInset, "fillinset", cfg.FillInset, "Pixels to keep fills away from the edge of each region, which the outline already burns; 1 skips the outline pixels")
+	fs.BoolVar(&cfg.PerimeterFirst, "perimeter-first", cfg.PerimeterFirst, "Cut each fill region's perimeter before filling it")
+	fs.Float64Var(&cfg.Tolerance, "tolerance", cfg.Tolerance, "How far (pixels) simplified outlines may stray from the traced ones; larger gives smaller files with less detail, under 1 keeps every point")
+	fs.Float64Var(&cfg.Tolerance, "simplifytolerance", cfg.Tolerance, "Alias for -tolerance")
+	fs.Float64Var(&cfg.MergeCollinear, "collinear", cfg.MergeCollinear, "Drop outline points within this distance (pixels) of the straight cut past them, shrinking straight runs to one move; 0 disables")
+	fs.BoolVar(&cfg.CloseLoops, "closeloops", cfg.CloseLoops, "Close outlines whose end stops within -closegap of their start")
+	fs.Float64Var(&cfg.CloseGap, "closegap", cfg.CloseGap, "Widest gap (pixels) between an outline's ends that -closeloops bridges")
+	fs.BoolVar(&cfg.SnapLoops, "snaploops", cfg.SnapLoops, "With -closeloops, move the outline's end onto its start instead of adding a closing cut")
+	fs.IntVar(&cfg.Smooth, "smooth", cfg.Smooth, "Rounds of Chaikin corner cutting to smooth each outline before it is cut; 0 disables")
+	fs.Float64Var(&cfg.Kerf, "kerf", cfg.Kerf, "Beam width (mm) to compensate for: closed outlines move out by half of it, holes in")
+	fs.BoolVar(&cfg.Arcs, "arcs", cfg.Arcs, "Replace curved runs of outline segments with G2/G3 arcs")
+	fs.BoolVar(&cfg.Vector, "vector", cfg.Vector, "Cut SVG outlines from their path geometry instead of tracing the rendered pixels")
+	calibrate := fs.Bool("calibration-grid", false, "Generate a power/feed test grid instead of converting an image")
+	grid := DefaultCalibrationGrid()
+	fs.IntVar(&grid.Cols, "grid-cols", grid.Cols, "Calibration grid columns, stepping power")
+	fs.IntVar(&grid.Rows, "grid-rows", grid.Rows, "Calibration grid rows, stepping feed")
+	fs.IntVar(&grid.PowerMin, "grid-power-min", grid.PowerMin, "Calibration grid power in the first column")
+	fs.IntVar(&grid.PowerMax, "grid-power-max", grid.PowerMax, "Calibration grid power in the last column")
+	fs.Float64Var(&grid.FeedMin, "grid-feed-min", grid.FeedMin, "Calibration grid feed (mm/min) in the first row")
+	fs.Float64Var(&grid.FeedMax, "grid-feed-max", grid.FeedMax, "Calibration grid feed (mm/min) in the last row")
+	fs.Float64Var(&grid.CellSize, "grid-cell", grid.CellSize, "Calibration grid square size (mm)")
+	fs.BoolVar(&cfg.AutoCrop, "autocrop", cfg.AutoCrop, "Trim the background around the design before scaling to -width/-height")
+	fs.IntVar(&cfg.CropMargin, "margin", cfg.CropMargin, "Border (pixels) to keep around the design with -autocrop")
+	mirrorX := fs.Bool("mirrorx", false, "Mirror the image left to right before conversion")
+	mirrorY := fs.Bool("mirrory", false, "Mirror the image top to bottom before conversion")
+	progress := fs.Bool("progress", false, "Print conversion progress to stderr")
+	rotate := fs.Float64("rotate", 0, "Rotate the image clockwise by this many degrees before conversion")
+	split := fs.Bool("split-engrave-cut", false, "Write the engraving and the cut-out outline to separate -engrave and -cut files")
+	cutPower := fs.Int("cutpower", 1000, "Laser power (S value) for the cut file of -split-engrave-cut and the -cutcolor outlines")
+	fs.Float64Var(&cfg.CutColorFeed, "cutfeed", cfg.CutColorFeed, "Feed rate (mm/min) for the -cutcolor outlines; 0 uses -feed")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if *showVersion {
+		fmt.Fprintf(stdout, "app %s\ninput formats: %s\noutput formats: %s\n", buildVersion(),
+			strings.Join(SupportedInputFormats(), ", "), strings.Join(SupportedOutputFormats(), ", "))
+		return exitOK
+	}
+
+	if *configFile != "" {
+		// Start over from the profile, then parse the command line again
+		// so the flags given there win over it.
+		profile, err := LoadConfig(*configFile)
+		if err != nil {
+			logger.Printf("failed to read config %q: %v", *configFile, err)
+			return exitLoad
+		}
+		cfg = profile
+		*threshold = uint(cfg.Threshold)
+		*bgThreshold = uint(cfg.BackgroundThreshold)
+		*alphaThreshold = uint(cfg.AlphaThreshold)
+		if cfg.ReturnToOffset {
+			*returnTo = "offset"
+		}
+		*pauseAt = pauseBoundaries(cfg)
+		if cfg.Precision != nil {
+			*precision = *cfg.Precision
+		}
+		if err := fs.Parse(args); err != nil {
+			return exitUsage
+		}
+	}
+
+	if *inputFile == "" && !*calibrate && *dumpConfig == "" {
+		fs.Usage()
+		return exitUsage
+	}
+	cfg.Threshold = uint8(*threshold)
+	if *bgThreshold < 1 || *bgThreshold > 255 {
+		logger.Printf("-bgthreshold must be between 1 and 255")
+		return exitUsage
+	}
+	cfg.BackgroundThreshold = uint8(*bgThreshold)
+	if *alphaThreshold > 255 {
+		logger.Printf("-alphathreshold must be between 0 and 255")
+		return exitUsage
+	}
+	cfg.AlphaThreshold = uint8(*alphaThreshold)
+
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	// A profile's cut colour power stands unless -cutpower is given.
+	if set["cutpower"] || cfg.CutColorPower == 0 {
+		cfg.CutColorPower = *cutPower
+	}
+	if cfg.Threshold != 0 {
+		logger.Printf("-threshold, and threshold in profiles, is deprecated and ignored; use -bgthreshold")
+	}
+	if set["offset"] && !set["offsetx"] {
+		cfg.OffsetX = *offset
+	}
+	if set["offset"] && !set["offsety"] {
+		cfg.OffsetY = *offset
+	}
+
+	if cfg.DPI < 0 {
+		logger.Printf("-dpi must not be negative")
+		return exitUsage
+	}
+	if cfg.Centered && cfg.FromContent {
+		logger.Printf("-centered and -origin-offset-from-content each place the content; choose one")
+		return exitUsage
+	}
+	if cfg.Scale <= 0 {
+		logger.Printf("-scale must be positive")
+		return exitUsage
+	}
+	if cfg.DPI > 0 && (set["width"] || set["height"]) {
+		logger.Printf("-dpi sets the size itself and cannot be combined with -width or -height")
+		return exitUsage
+	}
+
+	if cfg.Units != UnitsMM && cfg.Units != UnitsInch {
+		logger.Printf("unknown -units %q", cfg.Units)
+		return exitUsage
+	}
+
+	if cfg.LaserMode != LaserConstant && cfg.LaserMode != LaserDynamic {
+		logger.Printf("unknown -lasermode %q", cfg.LaserMode)
+		return exitUsage
+	}
+
+	if cfg.EndCommand != EndM2 && cfg.EndCommand != EndM30 && cfg.EndCommand != EndNone {
+		logger.Printf("unknown -endcmd %q", cfg.EndCommand)
+		return exitUsage
+	}
+
+	if cfg.FillMode != FillZigZag && cfg.FillMode != FillConcentric {
+		logger.Printf("unknown -fillmode %q", cfg.FillMode)
+		return exitUsage
+	}
+
+	if cfg.EdgeDetect != EdgeNeighbor && cfg.EdgeDetect != EdgeSobel {
+		logger.Printf("unknown -edgedetect %q", cfg.EdgeDetect)
+		return exitUsage
+	}
+
+	if cfg.Adaptive != "" && cfg.Adaptive != AdaptiveMean && cfg.Adaptive != AdaptiveGaussian {
+		logger.Printf("unknown -adaptive %q", cfg.Adaptive)
+		return exitUsage
+	}
+	if cfg.Adaptive != "" && cfg.AutoThreshold {
+		logger.Printf("-adaptive and -autothreshold each pick the threshold; choose one")
+		return exitUsage
+	}
+	if cfg.AdaptiveWindow < 3 || cfg.AdaptiveWindow%2 == 0 {
+		logger.Printf("-adaptivewindow must be an odd number of at least 3")
+		return exitUsage
+	}
+
+	if cfg.AirAssistCommand != "M7" && cfg.AirAssistCommand != "M8" {
+		logger.Printf("unknown -aircmd %q", cfg.AirAssistCommand)
+		return exitUsage
+	}
+
+	if cfg.Passes < 1 || cfg.FillPasses < 1 {
+		logger.Printf("-passes and -fillpasses must be at least 1")
+		return exitUsage
+	}
+	if cfg.PassAngleJitter < 0 {
+		logger.Printf("-pass-angle-jitter must not be negative")
+		return exitUsage
+	}
+	if cfg.Denoise < 0 || cfg.Blur < 0 {
+		logger.Printf("-denoise and -blur must not be negative")
+		return exitUsage
+	}
+	if cfg.Supersample < 0 || cfg.Supersample > 8 {
+		logger.Printf("-supersample must be between 0 and 8")
+		return exitUsage
+	}
+	if cfg.Brightness < -255 || cfg.Brightness > 255 {
+		logger.Printf("-brightness must be between -255 and 255")
+		return exitUsage
+	}
+	if cfg.Contrast <= 0 || cfg.Gamma <= 0 {
+		logger.Printf("-contrast and -gamma must be positive")
+		return exitUsage
+	}
+	if cfg.Tolerance < 0 {
+		logger.Printf("-tolerance must not be negative")
+		return exitUsage
+	}
+	if cfg.MergeCollinear < 0 {
+		logger.Printf("-collinear must not be negative")
+		return exitUsage
+	}
+	if cfg.CloseGap <= 0 {
+		logger.Printf("-closegap must be positive")
+		return exitUsage
+	}
+	if cfg.Smooth < 0 || cfg.Smooth > 8 {
+		logger.Printf("-smooth must be between 0 and 8")
+		return exitUsage
+	}
+	if cfg.StippleSpacing < 0 || cfg.StippleDwell < 0 {
+		logger.Printf("-stipplespacing and -stippledwell must not be negative")
+		return exitUsage
+	}
+	if cfg.MaxPaths < 0 {
+		logger.Printf("-maxpaths must not be negative")
+		return exitUsage
+	}
+	if cfg.FillInset < 0 {
+		logger.Printf("-fillinset must not be negative")
+		return exitUsage
+	}
+	if cfg.Kerf < 0 {
+		logger.Printf("-kerf must not be negative")
+		return exitUsage
+	}
+	if cfg.Dwell < 0 {
+		logger.Printf("-dwell must not be negative")
+		return exitUsage
+	}
+	if cfg.LeadIn < 0 || cfg.LeadOut < 0 || cfg.LeadFeed < 0 {
+		logger.Printf("-leadin, -leadout and -leadfeed must not be negative")
+		return exitUsage
+	}
+	if cfg.Accel < 0 {
+		logger.Printf("-accel must not be negative")
+		return exitUsage
+	}
+	if cfg.Overscan < 0 {
+		logger.Printf("-overscan must not be negative")
+		return exitUsage
+	}
+	if cfg.MinFillArea < 0 || cfg.MinSegment < 0 || cfg.MinPathPoints < 1 {
+		logger.Printf("-minfill and -minsegment must not be negative and -minpath must be at least 1")
+		return exitUsage
+	}
+	if cfg.CropMargin < 0 {
+		logger.Printf("-margin must not be negative")
+		return exitUsage
+	}
+	for _, hex := range []string{cfg.CutColor, cfg.EngraveColor} {
+		if _, err := parseHexColor(hex); hex != "" && err != nil {
+			logger.Printf("-cutcolor and -engravecolor: %v", err)
+			return exitUsage
+		}
+	}
+	if cfg.CutColor != "" || cfg.EngraveColor != "" {
+		if cfg.Invert || cfg.AutoCrop || cfg.Vector {
+			logger.Printf("-cutcolor and -engravecolor cannot be combined with -invert, -autocrop or -vector")
+			return exitUsage
+		}
+		if cfg.ColorTolerance < 0 {
+			logger.Printf("-colortolerance must not be negative")
+			return exitUsage
+		}
+	}
+	if cfg.Vector && (cfg.AutoCrop || *rotate != 0 || *mirrorX || *mirrorY) {
+		logger.Printf("-vector cannot be combined with -autocrop, -rotate, -mirrorx or -mirrory")
+		return exitUsage
+	}
+	if *svgDPI < 0 {
+		logger.Printf("-svgdpi must not be negative")
+		return exitUsage
+	}
+	if cfg.BedWidth < 0 || cfg.BedHeight < 0 {
+		logger.Printf("-bedwidth and -bedheight must not be negative")
+		return exitUsage
+	}
+	if cfg.TravelSafe < 0 {
+		logger.Printf("-travelsafe must not be negative")
+		return exitUsage
+	}
+	if cfg.PassDepth < 0 {
+		logger.Printf("-passdepth must not be negative")
+		return exitUsage
+	}
+
+	if cfg.CutDepth <= 0 || cfg.SafeZ <= 0 || cfg.PlungeFeed <= 0 {
+		logger.Printf("-cutdepth, -safez and -plungefeed must be positive")
+		return exitUsage
+	}
+
+	switch *returnTo {
+	case "origin":
+		cfg.ReturnToOffset = false
+	case "offset":
+		cfg.ReturnToOffset = true
+	default:
+		logger.Printf("unknown -returnto %q", *returnTo)
+		return exitUsage
+	}
+
+	if *precision != -1 {
+		if *precision < 0 || *precision > 6 {
+			logger.Printf("-precision must be between 0 and 6")
+			return exitUsage
+		}
+		cfg.Precision = precision
+	}
+
+	cfg.PausePasses, cfg.PausePhases = false, false
+	for _, boundary := range strings.Split(*pauseAt, ",") {
+		switch strings.TrimSpace(boundary) {
+		case "":
+		case "passes":
+			cfg.PausePasses = true
+		case "phases":
+			cfg.PausePhases = true
+		default:
+			logger.Printf("unknown -pauseat boundary %q: want passes or phases", boundary)
+			return exitUsage
+		}
+	}
+
+	strategy, ok := grayStrategies[*grayMode]
+	if !ok {
+		logger.Printf("unknown gray conversion %q", *grayMode)
+		return exitUsage
+	}
+	cfg.Gray = strategy
 
-	if *inputFile == "" {
-		flag.Usage()
-		os.Exit(1)
+	if !slices.Contains(outputFormats, *outputFormat) {
+		logger.Printf("unknown output format %q", *outputFormat)
+		return exitUsage
 	}
 
-	img, err := LoadSVG(*inputFile)
+	if *inputFile == "-" && *inputFormat == "" {
+		logger.Printf("-input - needs -informat to say what the image is")
+		return exitUsage
+	}
+	if *outputFile == "-" && *split {
+		logger.Printf("-split-engrave-cut writes two files and cannot write to stdout")
+		return exitUsage
+	}
+	// Messages move out of the way of a program written to stdout.
+	report := stdout
+	if *outputFile == "-" {
+		report = stderr
+	}
+
+	for _, t := range []struct {
+		path string
+		dst  *string
+	}{{*headerFile, &cfg.Header}, {*footerFile, &cfg.Footer}} {
+		if t.path == "" {
+			continue
+		}
+		data, err := os.ReadFile(t.path)
+		if err != nil {
+			logger.Printf("failed to read template %q: %v", t.path, err)
+			return exitLoad
+		}
+		*t.dst = string(data)
+	}
+
+	if *dumpConfig != "" {
+		data, err := json.MarshalIndent(cfg, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*dumpConfig, append(data, '\n'), 0o644)
+		}
+		if err != nil {
+			logger.Printf("failed to write config %q: %v", *dumpConfig, err)
+			return exitWrite
+		}
+		if *inputFile == "" && !*calibrate {
+			return exitOK
+		}
+	}
+
+	if *progress {
+		last := -1
+		cfg.Progress = func(stage string, fraction float64) {
+			// Only whole-percent changes, so huge jobs don't flood stderr.
+			if pct := int(fraction * 100); pct != last {
+				last = pct
+				fmt.Fprintf(stderr, "%s %d%%\n", stage, pct)
+			}
+		}
+	}
+
+	if *calibrate && (grid.Cols < 1 || grid.Rows < 1 || grid.CellSize <= 0) {
+		logger.Printf("-grid-cols, -grid-rows and -grid-cell must be positive")
+		return exitUsage
+	}
+
+	stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
+	if err != nil {
+		logger.Printf("failed to start profiling: %v", err)
+		return exitWrite
+	}
+	defer func() {
+		if perr := stopProfiles(); perr != nil {
+			logger.Printf("failed to write profile: %v", perr)
+		}
+	}()
+
+	// writeJobs writes output with the settings in cfg, split into engrave
+	// and cut files when asked. convert writes one job in the chosen
+	// format once its file is open and measures it.
+	writeJobs := func(input, output string, cfg Config, convert func(io.Writer, Config) (JobStats, error)) int {
+		jobs := []outputJob{{output, cfg}}
+		if *split {
+			jobs = splitEngraveCut(output, cfg, *cutPower)
+		}
+
+		for _, job := range jobs {
+			if *validate {
+				// Run the whole conversion but throw the program away.
+				stats, err := convert(io.Discard, job.cfg)
+				if err != nil {
+					logger.Printf("failed to convert %q: %v", input, err)
+					return exitConvert
+				}
+				fmt.Fprintf(report, "%s validated, not written\n", job.path)
+				fmt.Fprintf(report, "Job: %v\n", stats)
+				continue
+			}
+
+			stats, code := writeJob(job, convert, logger, input, stdout)
+			if code != exitOK {
+				return code
+			}
+			dest := job.path
+			if dest == "-" {
+				dest = "stdout"
+			}
+			fmt.Fprintf(report, "G-code successfully written to %s\n", dest)
+			if *outputFormat != "dxf" {
+				fmt.Fprintf(report, "Job: %v\n", stats)
+			}
+		}
+		return exitOK
+	}
+
+	if *calibrate {
+		return writeJobs("", *outputFile, cfg, func(w io.Writer, cfg Config) (JobStats, error) {
+			tp := BuildCalibrationGrid(grid, cfg)
+			moves, err := newBedGuard(cfg).filterMoves(tp.Moves)
+			if err != nil {
+				return JobStats{}, err
+			}
+			tp.Moves = moves
+			return tp.Stats(), writeToolpath(w, tp, cfg, *outputFormat)
+		})
+	}
+
+	// convertFile converts one input image into output.
+	convertFile := func(input, output, dump, previewPath string) int {
+		cfg := cfg
+		cfg.Source = filepath.Base(input)
+		if input == "-" {
+			cfg.Source = "stdin"
+		}
+
+		var img image.Image
+		var err error
+		switch {
+		case input == "-":
+			img, err = decodeImage(stdin, *inputFormat, *svgDPI, logger)
+		case *inputFormat != "":
+			img, err = loadImageAs(input, *inputFormat, *svgDPI, logger)
+		default:
+			img, err = loadImageAs(input, filepath.Ext(input), *svgDPI, logger)
+		}
+		if err != nil {
+			logger.Printf("failed to load image %q: %v", input, err)
+			return exitLoad
+		}
+
+		img = MirrorImage(img, *mirrorX, *mirrorY)
+		if *rotate != 0 {
+			img = RotateImage(img, *rotate)
+			// Turn the target size with the image so each pixel keeps its
+			// scale; right angles simply swap width and height.
+			cfg.Width, cfg.Height = rotatedSize(cfg.Width, cfg.Height, *rotate)
+		}
+
+		if dump != "" && !*validate {
+			if err := writePNG(dump, ProcessImage(img, cfg)); err != nil {
+				logger.Printf("failed to write processed image %q: %v", dump, err)
+				return exitWrite
+			}
+		}
+
+		// The preview must show the toolpath the job is written from, so
+		// with one asked for the toolpath is built once, up front, and
+		// written from; without one the G-code streams as it is made.
+		var built *Toolpath
+		if previewPath != "" && !*validate {
+			built, err = BuildToolpath(img, cfg)
+			if err != nil {
+				logger.Printf("failed to convert %q: %v", input, err)
+				return exitConvert
+			}
+			if err := writePreview(previewPath, built); err != nil {
+				logger.Printf("failed to write preview %q: %v", previewPath, err)
+				return exitWrite
+			}
+		}
+
+		if cfg.AutoThreshold {
+			fmt.Fprintf(report, "Background threshold picked for %s: %d\n", input, AutoThreshold(img, cfg))
+		}
+
+		return writeJobs(input, output, cfg, func(w io.Writer, cfg Config) (JobStats, error) {
+			// Split jobs each have settings of their own, and a drawing
+			// leaves the fills out.
+			if built != nil && !*split && *outputFormat != "dxf" {
+				return built.Stats(), writeToolpath(w, built, cfg, *outputFormat)
+			}
+			switch *outputFormat {
+			case "json":
+				tp, err := BuildToolpath(img, cfg)
+				if err != nil {
+					return JobStats{}, err
+				}
+				return tp.Stats(), writeToolpath(w, tp, cfg, *outputFormat)
+			case "dxf":
+				// A drawing has no feeds or travel to measure.
+				return JobStats{}, WriteDXF(w, img, cfg)
+			default:
+				return WriteGCodeStats(w, img, cfg)
+			}
+		})
+	}
+
+	if *inputFile == "-" {
+		return convertFile(*inputFile, *outputFile, *dumpProcessed, *preview)
+	}
+
+	inputs, batch, err := expandInputs(*inputFile)
+	if err != nil {
+		logger.Printf("failed to find input %q: %v", *inputFile, err)
+		return exitLoad
+	}
+	if !batch {
+		return convertFile(*inputFile, *outputFile, *dumpProcessed, *preview)
+	}
+
+	// Validation writes nothing, so it doesn't need the output directory.
+	if !*validate {
+		if err := os.MkdirAll(*outputDir, 0o755); err != nil {
+			logger.Printf("failed to create output directory %q: %v", *outputDir, err)
+			return exitWrite
+		}
+	}
+
+	// One bad file shouldn't cost the rest of the batch; failures are
+	// summed up at the end and the first one sets the exit code.
+	ext := ".gcode"
+	if *outputFormat != "gcode" {
+		ext = "." + *outputFormat
+	}
+	code := exitOK
+	var failed []string
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)) + ext
+		if c := convertFile(input, filepath.Join(*outputDir, name), "", ""); c != exitOK {
+			failed = append(failed, input)
+			if code == exitOK {
+				code = c
+			}
+		}
+	}
+	if len(failed) > 0 {
+		logger.Printf("%d of %d inputs failed: %s", len(failed), len(inputs), strings.Join(failed, ", "))
+	}
+	return code
+}
+
+// pauseBoundaries returns the -pauseat value that selects the pauses cfg
+// already asks for.
+func pauseBoundaries(cfg Config) string {
+	var boundaries []string
+	if cfg.PausePasses {
+		boundaries = append(boundaries, "passes")
+	}
+	if cfg.PausePhases {
+		boundaries = append(boundaries, "phases")
+	}
+	return strings.Join(boundaries, ",")
+}
+
+// expandInputs resolves the -input argument. A directory stands for every
+// image in it and a pattern with glob characters for every file it
+// matches; either makes a batch. Anything else is a single file.
+func expandInputs(input string) ([]string, bool, error) {
+	if info, err := os.Stat(input); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(input)
+		if err != nil {
+			return nil, true, err
+		}
+
+		var inputs []string
+		for _, e := range entries {
+			if !e.IsDir() && isImageFile(e.Name()) {
+				inputs = append(inputs, filepath.Join(input, e.Name()))
+			}
+		}
+		if len(inputs) == 0 {
+			return nil, true, errors.New("no images in directory")
+		}
+		return inputs, true, nil
+	}
+
+	if !strings.ContainsAny(input, "*?[") {
+		return []string{input}, false, nil
+	}
+
+	inputs, err := filepath.Glob(input)
 	if err != nil {
-		log.Fatalf("failed to load SVG: %v", err)
+		return nil, true, err
+	}
+	if len(inputs) == 0 {
+		return nil, true, errors.New("no files match")
 	}
+	return inputs, true, nil
+}
+
+// outputJob is one file the run writes and the settings it is made with.
+type outputJob struct {
+	path string
+	cfg  Config
+}
+
+// splitEngraveCut turns one job into an engraving file, with the fill and
+// a single light outline at the normal power, and a cut file with only the
+// outline at cutPower for the configured passes. The files are named after
+// output with -engrave and -cut before the extension.
+func splitEngraveCut(output string, cfg Config, cutPower int) []outputJob {
+	ext := filepath.Ext(output)
+	base := strings.TrimSuffix(output, ext)
+
+	engrave := cfg
+	engrave.Passes = 1
+	engrave.PassDepth = 0
 
-	gcode, err := ConvertToGCode(img, *width, *height, *offset, uint8(*threshold))
+	cut := cfg
+	cut.Power = cutPower
+	cut.NoFill = true
+
+	return []outputJob{
+		{base + "-engrave" + ext, engrave},
+		{base + "-cut" + ext, cut},
+	}
+}
+
+// writeJob creates job's output file and converts into it, returning the
+// job's stats and the exit code for the outcome. A failed job's partial file
+// is removed. A job path of "-" is written to stdout instead, held back
+// until the job is complete so a failure leaves no partial program there.
+func writeJob(job outputJob, convert func(io.Writer, Config) (JobStats, error), logger *log.Logger, input string, stdout io.Writer) (JobStats, int) {
+	var stats JobStats
+	var err error
+	if job.path == "-" {
+		var buf bytes.Buffer
+		if stats, err = convert(&buf, job.cfg); err == nil {
+			if _, err := buf.WriteTo(stdout); err != nil {
+				logger.Printf("failed to write output: %v", err)
+				return JobStats{}, exitWrite
+			}
+		}
+	} else {
+		var out *os.File
+		out, err = os.Create(job.path)
+		if err != nil {
+			logger.Printf("failed to write output file %q: %v", job.path, err)
+			return JobStats{}, exitWrite
+		}
+
+		stats, err = convert(out, job.cfg)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			// Don't leave a truncated program behind for a sender to
+			// pick up.
+			os.Remove(job.path)
+		}
+	}
 	if err != nil {
-		log.Fatalf("failed to convert image to G-code: %v", err)
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			logger.Printf("failed to write output file %q: %v", job.path, err)
+			return JobStats{}, exitWrite
+		}
+		logger.Printf("failed to convert %q: %v", input, err)
+		return JobStats{}, exitConvert
 	}
+	return stats, exitOK
+}
 
-	if err = os.WriteFile(*outputFile, []byte(gcode), 0644); err != nil {
-		log.Fatalf("failed to write output file: %v", err)
+// outputFormats are the formats writeToolpath writes, by -output-format
+// name.
+var outputFormats = []string{"gcode", "json", "dxf"}
+
+// SupportedOutputFormats returns the formats a job can be written in, by
+// their -output-format names.
+func SupportedOutputFormats() []string {
+	return slices.Clone(outputFormats)
+}
+
+// writeToolpath writes a built toolpath to w in format: G-code, the
+// intermediate JSON toolpath or DXF.
+func writeToolpath(w io.Writer, tp *Toolpath, cfg Config, format string) error {
+	switch format {
+	case "json":
+		data, err := ToolpathToJSON(tp)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	case "dxf":
+		return tp.WriteDXF(w)
+	default:
+		return tp.WriteGCode(w, cfg)
 	}
+}
 
-	fmt.Printf("G-code successfully written to %s\n", *outputFile)
+// writePreview renders tp to an SVG file.
+func writePreview(path string, tp *Toolpath) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := tp.WriteSVG(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
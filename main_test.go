@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// runCLI drives run with args and returns its exit code and what it wrote
+// to stdout and stderr.
+func runCLI(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, strings.NewReader(""), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// testPNG encodes img as name in dir and returns its path.
+func testPNG(t *testing.T, dir, name string, img image.Image) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readFile returns the contents of path, failing t if it cannot be read.
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRunExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	square := testPNG(t, dir, "square.png", rect(40, 40, 10, 10, 30, 30))
+	white := testPNG(t, dir, "white.png", rect(40, 40, 0, 0, 0, 0))
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"ok", []string{"-input", square, "-output", filepath.Join(dir, "out.gcode")}, exitOK},
+		{"unknown flag", []string{"-nosuchflag"}, exitUsage},
+		{"no input", nil, exitUsage},
+		{"bad value", []string{"-input", square, "-passes", "-1"}, exitUsage},
+		{"missing input", []string{"-input", filepath.Join(dir, "missing.png"), "-output", filepath.Join(dir, "out.gcode")}, exitLoad},
+		{"nothing to engrave", []string{"-input", white, "-output", filepath.Join(dir, "out.gcode")}, exitConvert},
+		{"unwritable output", []string{"-input", square, "-output", filepath.Join(dir, "no", "such", "dir", "out.gcode")}, exitWrite},
+	}
+	for _, tt := range tests {
+		if code, _, stderr := runCLI(t, tt.args...); code != tt.want {
+			t.Errorf("%s: exit code %d, want %d; stderr:\n%s", tt.name, code, tt.want, stderr)
+		}
+	}
+}
+
+func TestRunWritesGCode(t *testing.T) {
+	dir := t.TempDir()
+	input := testPNG(t, dir, "square.png", rect(40, 40, 10, 10, 30, 30))
+	output := filepath.Join(dir, "square.gcode")
+
+	code, stdout, stderr := runCLI(t, "-input", input, "-output", output)
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	gcode := readFile(t, output)
+	for _, want := range []string{"G21", "G90", "M3 S1000", "G1 X", "M5", "M2"} {
+		if !strings.Contains(gcode, want) {
+			t.Errorf("output lacks %q", want)
+		}
+	}
+	if !strings.Contains(stdout, output) {
+		t.Errorf("stdout does not name the output file:\n%s", stdout)
+	}
+}
+
+func TestRunDumpProcessed(t *testing.T) {
+	dir := t.TempDir()
+	src := annulus(50, 8, 20)
+	for i := range src.Pix {
+		if src.Pix[i] == 0 {
+			src.Pix[i] = uint8(i % 200)
+		}
+	}
+	input := testPNG(t, dir, "in.png", src)
+	dump := filepath.Join(dir, "processed.png")
+
+	code, _, stderr := runCLI(t, "-input", input, "-output", filepath.Join(dir, "out.gcode"),
+		"-dump-processed", dump, "-gamma", "1.5", "-denoise", "3")
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+
+	f, err := os.Open(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Gamma, cfg.Denoise = 1.5, 3
+	want := ProcessImage(src, cfg)
+	if got.Bounds() != want.Bounds() {
+		t.Fatalf("dump is %v, want %v", got.Bounds(), want.Bounds())
+	}
+	for y := 0; y < want.Bounds().Dy(); y++ {
+		for x := 0; x < want.Bounds().Dx(); x++ {
+			if g, w := color.GrayModel.Convert(got.At(x, y)).(color.Gray).Y, want.GrayAt(x, y).Y; g != w {
+				t.Fatalf("pixel %d,%d is %d in the dump, %d processed", x, y, g, w)
+			}
+		}
+	}
+}
+
+func TestRunWritesProfiles(t *testing.T) {
+	dir := t.TempDir()
+	input := testPNG(t, dir, "square.png", rect(40, 40, 10, 10, 30, 30))
+	cpu, mem := filepath.Join(dir, "cpu.prof"), filepath.Join(dir, "mem.prof")
+
+	code, _, stderr := runCLI(t, "-input", input, "-output", filepath.Join(dir, "out.gcode"), "-cpuprofile", cpu, "-memprofile", mem)
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	for _, path := range []string{cpu, mem} {
+		if fi, err := os.Stat(path); err != nil || fi.Size() == 0 {
+			t.Errorf("%s missing or empty: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestRunCustomHeaderAndFooter(t *testing.T) {
+	dir := t.TempDir()
+	input := testPNG(t, dir, "square.png", rect(40, 40, 10, 10, 30, 30))
+	header := filepath.Join(dir, "header.gcode")
+	footer := filepath.Join(dir, "footer.gcode")
+	if err := os.WriteFile(header, []byte("; my machine\nG21 G90 F{FEED} S{POWER}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(footer, []byte("M5\nG0 X0 Y0\nM30\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "out.gcode")
+
+	code, _, stderr := runCLI(t, "-input", input, "-output", output, "-header", header, "-footer", footer, "-feed", "900")
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	gcode := readFile(t, output)
+	if !strings.HasPrefix(gcode, "; my machine\nG21 G90 F900 S1000\n") {
+		t.Errorf("output does not start with the header:\n%.80s", gcode)
+	}
+	if !strings.HasSuffix(gcode, "M5\nG0 X0 Y0\nM30\n") {
+		t.Errorf("output does not end with the footer:\n%s", gcode[max(0, len(gcode)-80):])
+	}
+}
+
+func TestRunSplitEngraveCut(t *testing.T) {
+	dir := t.TempDir()
+	input := testPNG(t, dir, "square.png", rect(40, 40, 10, 10, 30, 30))
+	code, _, stderr := runCLI(t, "-input", input, "-output", filepath.Join(dir, "job.gcode"),
+		"-split-engrave-cut", "-power", "300", "-cutpower", "900", "-passes", "2", "-comments")
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 3 {
+		t.Errorf("%d files in the output directory, want the input and two jobs", len(entries))
+	}
+
+	engrave := readFile(t, filepath.Join(dir, "job-engrave.gcode"))
+	if !strings.Contains(engrave, "; Fill region") || strings.Count(engrave, "; Path") != 1 {
+		t.Error("engrave file should hold the fill and a single outline")
+	}
+	if strings.Contains(engrave, "S900") {
+		t.Error("engrave file burns at the cut power")
+	}
+
+	cut := readFile(t, filepath.Join(dir, "job-cut.gcode"))
+	if strings.Contains(cut, "; Fill region") {
+		t.Error("cut file holds a fill")
+	}
+	if n := strings.Count(cut, "; Path"); n != 2 {
+		t.Errorf("cut file has %d outline cuts, want one per pass", n)
+	}
+	if strings.Count(cut, "M3 S900") != 2 || strings.Contains(cut, "S300") {
+		t.Error("cut file does not burn at the cut power alone")
+	}
+}
+
+// squareSVG is a 50×50 document with a black square in it.
+const squareSVG = `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 50 50"><rect x="10" y="10" width="30" height="30" fill="black"/></svg>`
+
+func TestRunCutColorSettings(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "layers.svg")
+	doc := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 60 30">` +
+		`<rect x="5" y="5" width="20" height="20" fill="#ff0000"/>` +
+		`<rect x="35" y="5" width="20" height="20" fill="#000000"/></svg>`
+	if err := os.WriteFile(input, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "job.gcode")
+	code, _, stderr := runCLI(t, "-input", input, "-output", output, "-width", "60", "-height", "30",
+		"-cutcolor", "#ff0000", "-engravecolor", "#000000",
+		"-power", "300", "-cutpower", "900", "-cutfeed", "400", "-comments")
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+
+	gcode := readFile(t, output)
+	fill := strings.Index(gcode, "; Fill region")
+	path := strings.Index(gcode, "; Path")
+	if path < 0 || fill < path {
+		t.Fatalf("want the red outline before the black fill:\n%s", gcode)
+	}
+	outline, engrave := gcode[path:fill], gcode[fill:]
+	if !strings.Contains(outline, "M3 S900") || !strings.Contains(outline, "F400") || strings.Contains(outline, "S300") {
+		t.Errorf("red outline not cut at -cutpower 900 and -cutfeed 400:\n%s", outline)
+	}
+	if !strings.Contains(engrave, "M3 S300") || !strings.Contains(engrave, "F1500") || strings.Contains(engrave, "S900") {
+		t.Errorf("black fill not engraved at -power 300 and the default feed:\n%s", engrave)
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	for _, name := range []string{"a.svg", "b.svg", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(in, name), []byte(squareSVG), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	code, _, stderr := runCLI(t, "-input", in, "-outputdir", out)
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if strings.Join(names, " ") != "a.gcode b.gcode" {
+		t.Errorf("output directory holds %v, want a.gcode and b.gcode", names)
+	}
+	for _, name := range names {
+		if !strings.Contains(readFile(t, filepath.Join(out, name)), "G1 X") {
+			t.Errorf("%s has no cuts", name)
+		}
+	}
+}
+
+func TestRunValidate(t *testing.T) {
+	dir := t.TempDir()
+	input := testPNG(t, dir, "square.png", rect(40, 40, 10, 10, 30, 30))
+	output := filepath.Join(dir, "square.gcode")
+
+	code, stdout, stderr := runCLI(t, "-validate", "-input", input, "-output", output)
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("validating wrote %s (stat error %v)", output, err)
+	}
+	if !strings.Contains(stdout, "Job: X ") {
+		t.Errorf("stdout has no job stats:\n%s", stdout)
+	}
+
+	blank := testPNG(t, dir, "blank.png", rect(40, 40, 0, 0, 0, 0))
+	if code, _, _ := runCLI(t, "-validate", "-input", blank, "-output", output); code != exitConvert {
+		t.Errorf("validating a blank image: exit code %d, want %d", code, exitConvert)
+	}
+}
+
+func TestRunPreview(t *testing.T) {
+	dir := t.TempDir()
+	input := testPNG(t, dir, "square.png", rect(40, 40, 10, 10, 30, 30))
+	plain, previewed := filepath.Join(dir, "plain.gcode"), filepath.Join(dir, "previewed.gcode")
+	preview := filepath.Join(dir, "preview.svg")
+
+	if code, _, stderr := runCLI(t, "-input", input, "-output", plain); code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	if code, _, stderr := runCLI(t, "-input", input, "-output", previewed, "-preview", preview); code != exitOK {
+		t.Fatalf("with -preview: exit code %d; stderr:\n%s", code, stderr)
+	}
+	if !strings.HasPrefix(readFile(t, preview), "<svg") {
+		t.Error("preview is not an SVG document")
+	}
+	if readFile(t, previewed) != readFile(t, plain) {
+		t.Error("G-code written alongside a preview differs from the plain conversion")
+	}
+
+	// Failing to convert is a conversion error however the preview was
+	// asked for, and leaves no preview behind.
+	blank := testPNG(t, dir, "blank.png", rect(40, 40, 0, 0, 0, 0))
+	blankPreview := filepath.Join(dir, "blank.svg")
+	if code, _, _ := runCLI(t, "-input", blank, "-output", previewed, "-preview", blankPreview); code != exitConvert {
+		t.Errorf("previewing a blank image: exit code %d, want %d", code, exitConvert)
+	}
+	if _, err := os.Stat(blankPreview); !os.IsNotExist(err) {
+		t.Errorf("failed conversion wrote a preview (stat error %v)", err)
+	}
+}
+
+func TestRunWarnsOfThreshold(t *testing.T) {
+	dir := t.TempDir()
+	input := testPNG(t, dir, "square.png", rect(40, 40, 10, 10, 30, 30))
+	output := filepath.Join(dir, "job.gcode")
+	if code, _, stderr := runCLI(t, "-input", input, "-output", output); code != exitOK || strings.Contains(stderr, "-threshold") {
+		t.Errorf("without -threshold: exit code %d; stderr:\n%s", code, stderr)
+	}
+	code, _, stderr := runCLI(t, "-input", input, "-output", output, "-threshold", "90")
+	if code != exitOK || !strings.Contains(stderr, "-threshold") || !strings.Contains(stderr, "ignored") {
+		t.Errorf("with -threshold: exit code %d, want a warning that it is ignored; stderr:\n%s", code, stderr)
+	}
+}
+
+func TestRunConfigFileWithOverride(t *testing.T) {
+	dir := t.TempDir()
+	input := testPNG(t, dir, "square.png", rect(40, 40, 10, 10, 30, 30))
+	profile := filepath.Join(dir, "profile.json")
+	if err := os.WriteFile(profile, []byte(`{"power": 400, "cut_feed": 900}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	dumped := filepath.Join(dir, "effective.json")
+
+	code, _, stderr := runCLI(t, "-config", profile, "-feed", "1500", "-dumpconfig", dumped,
+		"-input", input, "-output", filepath.Join(dir, "out.gcode"))
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+	}
+	var cfg Config
+	if err := json.Unmarshal([]byte(readFile(t, dumped)), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Power != 400 {
+		t.Errorf("power %d, want the profile's 400", cfg.Power)
+	}
+	if cfg.CutFeed != 1500 {
+		t.Errorf("feed %v, want the flag's 1500", cfg.CutFeed)
+	}
+	if cfg.TravelFeed != DefaultConfig().TravelFeed {
+		t.Errorf("travel feed %v, want the default %v", cfg.TravelFeed, DefaultConfig().TravelFeed)
+	}
+}
+
+func TestRunStdinToStdout(t *testing.T) {
+	var in bytes.Buffer
+	if err := png.Encode(&in, rect(40, 40, 10, 10, 30, 30)); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-input", "-", "-informat", "png", "-output", "-"}, &in, &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("exit code %d; stderr:\n%s", code, stderr.String())
+	}
+	gcode := stdout.String()
+	for _, want := range []string{"G21", "G1 X", "M2"} {
+		if !strings.Contains(gcode, want) {
+			t.Errorf("stdout lacks %q", want)
+		}
+	}
+	if strings.Contains(gcode, "successfully written") {
+		t.Error("the report went to stdout with the G-code")
+	}
+}
+
+func TestWriteJobToStdoutFailsWhole(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	logger := log.New(&stderr, "", 0)
+	partial := func(w io.Writer, _ Config) (JobStats, error) {
+		fmt.Fprintln(w, "G21\nG90\nM3 S1000\nG1 X10 Y10")
+		return JobStats{}, ErrTooManyPaths
+	}
+	if _, code := writeJob(outputJob{path: "-"}, partial, logger, "in.png", &stdout); code != exitConvert {
+		t.Errorf("exit code %d, want %d", code, exitConvert)
+	}
+	if stdout.Len() > 0 {
+		t.Errorf("failed job left a partial program on stdout:\n%s", stdout.String())
+	}
+}
+
+func TestSupportedFormats(t *testing.T) {
+	in, out := SupportedInputFormats(), SupportedOutputFormats()
+	for _, want := range []string{"svg", "png", "jpg"} {
+		if !slices.Contains(in, want) {
+			t.Errorf("input formats %v lack %s", in, want)
+		}
+	}
+	if !slices.Contains(out, "gcode") {
+		t.Errorf("output formats %v lack gcode", out)
+	}
+
+	code, stdout, _ := runCLI(t, "-version")
+	if code != exitOK {
+		t.Fatalf("-version exit code %d", code)
+	}
+	for _, want := range []string{"svg", "png", "gcode"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("-version output lacks %s:\n%s", want, stdout)
+		}
+	}
+}
+
+func TestRunSeedRepeatsJob(t *testing.T) {
+	dir := t.TempDir()
+	input := testPNG(t, dir, "tone.png", halftone(80, 80, 64, 192))
+	job := func(name, seed string) string {
+		output := filepath.Join(dir, name)
+		if code, _, stderr := runCLI(t, "-stipple", "-seed", seed, "-input", input, "-output", output); code != exitOK {
+			t.Fatalf("exit code %d; stderr:\n%s", code, stderr)
+		}
+		return readFile(t, output)
+	}
+	a, b, c := job("a.gcode", "3"), job("b.gcode", "3"), job("c.gcode", "4")
+	if a != b {
+		t.Error("two runs with -seed 3 wrote different jobs")
+	}
+	if a == c {
+		t.Error("-seed 3 and -seed 4 wrote the same job")
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import "math"
+
+// orderPathsNearest reorders paths greedily so each one starts at the point
+// closest to where the previous one ended, beginning at the origin. A path is
+// reversed when its far end is the closer entry point. Distances are
+// measured after scaling so non-uniform scales pick the shortest real move.
+func orderPathsNearest(paths []Path, scaleX, scaleY float64) []Path {
+	ends := func(i int) (vec, vec, bool) {
+		points := paths[i].points
+		if len(points) == 0 {
+			return vec{}, vec{}, false
+		}
+		start, end := points[0], points[len(points)-1]
+		return vec{float64(start.x), float64(start.y)}, vec{float64(end.x), float64(end.y)}, true
+	}
+
+	order, reversed := nearestOrder(len(paths), ends, scaleX, scaleY)
+	ordered := make([]Path, len(order))
+	for k, i := range order {
+		ordered[k] = paths[i]
+		if reversed[k] {
+			ordered[k] = reversePath(paths[i])
+		}
+	}
+	return ordered
+}
+
+// orderContoursNearest is orderPathsNearest for vector contours.
+func orderContoursNearest(contours [][]vec, scaleX, scaleY float64) [][]vec {
+	ends := func(i int) (vec, vec, bool) {
+		c := contours[i]
+		if len(c) == 0 {
+			return vec{}, vec{}, false
+		}
+		return c[0], c[len(c)-1], true
+	}
+
+	order, reversed := nearestOrder(len(contours), ends, scaleX, scaleY)
+	ordered := make([][]vec, len(order))
+	for k, i := range order {
+		ordered[k] = contours[i]
+		if reversed[k] {
+			ordered[k] = reverseVecs(contours[i])
+		}
+	}
+	return ordered
+}
+
+// orderFillsNearest reorders regions greedily so each zigzag fill starts at
+// the corner closest to where the previous one ended, beginning at from, and
+// returns them with the corner each starts from. Corners are those of the
+// region's bounding box in the frame of scan lines lineSpacing pixels apart
+// and angle degrees from the X axis; a fill is taken to end at the far end of
+// its last line, which a crosshatch pass does not account for. Regions of
+// fewer than minPoints pixels are never filled and go last, in the order
+// given.
+func orderFillsNearest(regions []Path, minPoints int, from vec, lineSpacing int, angle, scaleX, scaleY float64) ([]Path, []fillCorner) {
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	toImage := func(u, v int) vec {
+		return vec{float64(u)*cos - float64(v)*sin, float64(u)*sin + float64(v)*cos}
+	}
+
+	type fill struct {
+		region      Path
+		entry, exit [4]vec
+		corners     [4]fillCorner
+	}
+	var fills []fill
+	var small []Path
+	for _, region := range regions {
+		if len(region.points) < minPoints {
+			small = append(small, region)
+			continue
+		}
+
+		minU, minV, maxU, maxV := scanBounds(region.points, sin, cos)
+		lines := (maxV-minV)/lineSpacing + 1
+		lastV := minV + (lines-1)*lineSpacing
+
+		f := fill{region: region}
+		for i, c := range []fillCorner{{}, {right: true}, {bottom: true}, {bottom: true, right: true}} {
+			firstV, endV := minV, lastV
+			if c.bottom {
+				firstV, endV = lastV, minV
+			}
+			startU, endU := minU, maxU
+			if c.right {
+				startU, endU = maxU, minU
+			}
+			// Every other line runs back the way the first came.
+			if lines%2 == 0 {
+				endU = startU
+			}
+			f.entry[i], f.exit[i], f.corners[i] = toImage(startU, firstV), toImage(endU, endV), c
+		}
+		fills = append(fills, f)
+	}
+
+	ordered := make([]Path, 0, len(regions))
+	corners := make([]fillCorner, 0, len(regions))
+	pos := from
+	for len(fills) > 0 {
+		best, bestCorner, bestDist := 0, 0, math.MaxFloat64
+		for k, f := range fills {
+			for i, entry := range f.entry {
+				if d := scaledDistance(pos, entry, scaleX, scaleY); d < bestDist {
+					best, bestCorner, bestDist = k, i, d
+				}
+			}
+		}
+
+		f := fills[best]
+		fills = append(fills[:best], fills[best+1:]...)
+		ordered = append(ordered, f.region)
+		corners = append(corners, f.corners[bestCorner])
+		pos = f.exit[bestCorner]
+	}
+
+	ordered = append(ordered, small...)
+	corners = append(corners, make([]fillCorner, len(small))...)
+	return ordered, corners
+}
+
+// nearestOrder picks the greedy nearest-neighbour order of n paths whose
+// ends, in pixels, are given by ends. It returns the path indices in cutting
+// order and whether each is to be cut backwards. Paths for which ends
+// reports false are empty and left out.
+func nearestOrder(n int, ends func(i int) (start, end vec, ok bool), scaleX, scaleY float64) ([]int, []bool) {
+	remaining := make([]int, n)
+	for i := range remaining {
+		remaining[i] = i
+	}
+
+	var order []int
+	var reversed []bool
+	var pos vec
+
+	for len(remaining) > 0 {
+		best := -1
+		bestDist := math.MaxFloat64
+		reverse := false
+
+		for k, i := range remaining {
+			start, end, ok := ends(i)
+			if !ok {
+				continue
+			}
+
+			if d := scaledDistance(pos, start, scaleX, scaleY); d < bestDist {
+				best, bestDist, reverse = k, d, false
+			}
+			if d := scaledDistance(pos, end, scaleX, scaleY); d < bestDist {
+				best, bestDist, reverse = k, d, true
+			}
+		}
+
+		if best == -1 {
+			break
+		}
+
+		i := remaining[best]
+		remaining = append(remaining[:best], remaining[best+1:]...)
+
+		order = append(order, i)
+		reversed = append(reversed, reverse)
+		start, end, _ := ends(i)
+		pos = end
+		if reverse {
+			pos = start
+		}
+	}
+
+	return order, reversed
+}
+
+func reversePath(path Path) Path {
+	points := make([]Point, len(path.points))
+	for i, p := range path.points {
+		points[len(points)-1-i] = p
+	}
+	return Path{points: points}
+}
+
+func reverseVecs(pts []vec) []vec {
+	reversed := make([]vec, len(pts))
+	for i, p := range pts {
+		reversed[len(reversed)-1-i] = p
+	}
+	return reversed
+}
+
+func scaledDistance(a, b vec, scaleX, scaleY float64) float64 {
+	return math.Hypot((a.x-b.x)*scaleX, (a.y-b.y)*scaleY)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// WriteSVG renders the toolpath as an SVG drawing at its real size, cuts
+// and arcs in black, dwells as black dots and travel moves in light gray, so
+// a job can be checked before it is burnt. The Y axis points up, as on the
+// machine.
+func (tp *Toolpath) WriteSVG(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	// Travel starts from the origin, so it belongs in the picture.
+	e := tp.extents()
+	e.add(0, 0)
+	width, height := e.maxX-e.minX, e.maxY-e.minY
+	units := tp.Units
+	if units == "" {
+		units = UnitsMM
+	}
+
+	fmt.Fprintf(bw, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%g%s\" height=\"%g%s\" viewBox=\"%g %g %g %g\">\n",
+		width, units, height, units, e.minX, e.minY, width, height)
+	fmt.Fprintf(bw, "<g transform=\"matrix(1 0 0 -1 0 %g)\" fill=\"none\" stroke-width=\"1\" vector-effect=\"non-scaling-stroke\">\n", e.minY+e.maxY)
+
+	var x, y float64
+	var run []vec
+	flush := func() {
+		if len(run) > 1 {
+			pts := make([]string, len(run))
+			for i, p := range run {
+				pts[i] = fmt.Sprintf("%g,%g", p.x, p.y)
+			}
+			fmt.Fprintf(bw, "<polyline points=\"%s\" stroke=\"black\" vector-effect=\"non-scaling-stroke\"/>\n", strings.Join(pts, " "))
+		}
+		run = nil
+	}
+
+	for _, m := range tp.Moves {
+		switch m.Type {
+		case MoveTravel, MoveOverscan:
+			flush()
+			fmt.Fprintf(bw, "<line x1=\"%g\" y1=\"%g\" x2=\"%g\" y2=\"%g\" stroke=\"lightgray\" vector-effect=\"non-scaling-stroke\"/>\n", x, y, m.X, m.Y)
+		case MoveCut:
+			if len(run) == 0 {
+				run = append(run, vec{x, y})
+			}
+			run = append(run, vec{m.X, m.Y})
+		case MoveArcCW, MoveArcCCW:
+			if len(run) == 0 {
+				run = append(run, vec{x, y})
+			}
+			run = append(run, arcPoints(vec{x, y}, m)...)
+		case MoveDwell:
+			flush()
+			fmt.Fprintf(bw, "<line x1=\"%g\" y1=\"%g\" x2=\"%g\" y2=\"%g\" stroke=\"black\" stroke-linecap=\"round\" vector-effect=\"non-scaling-stroke\"/>\n", x, y, x, y)
+			continue
+		default:
+			continue
+		}
+		x, y = m.X, m.Y
+	}
+	flush()
+
+	bw.WriteString("</g>\n</svg>\n")
+	return bw.Flush()
+}
+
+// arcPoints flattens the arc m starting at from into points at most ten
+// degrees apart, ending at the arc's end point.
+func arcPoints(from vec, m Move) []vec {
+	cx, cy := from.x+m.I, from.y+m.J
+	r := math.Hypot(m.I, m.J)
+	start := math.Atan2(from.y-cy, from.x-cx)
+	sweep := arcSweep(from, m)
+
+	steps := max(1, int(math.Ceil(math.Abs(sweep)/(10*math.Pi/180))))
+	pts := make([]vec, 0, steps)
+	for i := 1; i < steps; i++ {
+		a := start + sweep*float64(i)/float64(steps)
+		pts = append(pts, vec{cx + r*math.Cos(a), cy + r*math.Sin(a)})
+	}
+	return append(pts, vec{m.X, m.Y})
+}
+
+// arcSweep returns the angle the arc m starting at from turns through, in
+// radians: positive counter-clockwise, negative clockwise. An arc ending
+// where it starts is a full circle.
+func arcSweep(from vec, m Move) float64 {
+	cx, cy := from.x+m.I, from.y+m.J
+	sweep := math.Atan2(m.Y-cy, m.X-cx) - math.Atan2(from.y-cy, from.x-cx)
+	if m.Type == MoveArcCCW && sweep <= 0 {
+		sweep += 2 * math.Pi
+	} else if m.Type == MoveArcCW && sweep >= 0 {
+		sweep -= 2 * math.Pi
+	}
+	return sweep
+}
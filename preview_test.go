@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteSVGPolylines(t *testing.T) {
+	// Two squares one pixel thick: outlines and nothing to fill.
+	img := squares(60, 60, 20, [2]int{5, 5}, [2]int{35, 35})
+	for _, c := range [][2]int{{5, 5}, {35, 35}} {
+		for y := c[1] + 1; y < c[1]+19; y++ {
+			for x := c[0] + 1; x < c[0]+19; x++ {
+				img.Pix[y*img.Stride+x] = 255
+			}
+		}
+	}
+	tp, err := BuildToolpath(img, DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tp.WriteSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	svg := buf.String()
+	if n := strings.Count(svg, "<polyline"); n != 2 {
+		t.Errorf("%d polylines, want one per square", n)
+	}
+	if n, want := strings.Count(svg, "<line"), countMoves(tp, MoveTravel); n != want {
+		t.Errorf("%d travel lines, want %d", n, want)
+	}
+
+	// Filled, every fill line is a polyline of its own.
+	tp, err = BuildToolpath(squares(60, 60, 20, [2]int{5, 5}, [2]int{35, 35}), DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	if err := tp.WriteSVG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if n, want := strings.Count(buf.String(), "<polyline"), countMoves(tp, MoveLaserOn); n != want {
+		t.Errorf("filled: %d polylines, want one per burn, %d", n, want)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"slices"
+	"sync"
+)
+
+// interiorByComponent groups the interior pixels of classes, a width×height
+// grid from classifyPixels, by the 4-connected foreground component they
+// lie in, each group in raster order. Components without interior pixels
+// are left out.
+//
+// Horizontal bands of the grid are labelled concurrently, one per worker,
+// and the labels of components crossing a band boundary are then joined.
+func interiorByComponent(classes []uint8, width, height, workers int) [][]int {
+	workers = min(max(workers, 1), max(height, 1))
+	band := (height + workers - 1) / workers
+	var bands [][2]int
+	for top := 0; top < height; top += band {
+		bands = append(bands, [2]int{top, min(top+band, height)})
+	}
+
+	// Within its band each foreground pixel is labelled with one more than
+	// the index of the first pixel of its piece of component; 0 is
+	// background.
+	labels := make([]int32, width*height)
+	var wg sync.WaitGroup
+	for _, b := range bands {
+		wg.Add(1)
+		go func(top, bottom int) {
+			defer wg.Done()
+			labelBand(classes, labels, width, top, bottom)
+		}(b[0], b[1])
+	}
+	wg.Wait()
+
+	// Pieces touching across a band boundary are one component, named by
+	// its smallest label: the piece starting first in raster order.
+	parent := map[int32]int32{}
+	find := func(l int32) int32 {
+		for {
+			p, ok := parent[l]
+			if !ok {
+				return l
+			}
+			l = p
+		}
+	}
+	for _, b := range bands[1:] {
+		above, below := (b[0]-1)*width, b[0]*width
+		for x := 0; x < width; x++ {
+			a, c := labels[above+x], labels[below+x]
+			if a == 0 || c == 0 {
+				continue
+			}
+			ra, rc := find(a), find(c)
+			if ra != rc {
+				parent[max(ra, rc)] = min(ra, rc)
+			}
+		}
+	}
+	root := make(map[int32]int32, len(parent))
+	for l := range parent {
+		root[l] = find(l)
+	}
+
+	// Gathered band by band, the pixels of each component stay in raster
+	// order.
+	perBand := make([]map[int32][]int, len(bands))
+	for i, b := range bands {
+		wg.Add(1)
+		go func(i, top, bottom int) {
+			defer wg.Done()
+			groups := map[int32][]int{}
+			for p := top * width; p < bottom*width; p++ {
+				if classes[p] != pixelInterior {
+					continue
+				}
+				r, ok := root[labels[p]]
+				if !ok {
+					r = labels[p]
+				}
+				groups[r] = append(groups[r], p)
+			}
+			perBand[i] = groups
+		}(i, b[0], b[1])
+	}
+	wg.Wait()
+
+	merged := map[int32][]int{}
+	for _, groups := range perBand {
+		for r, pixels := range groups {
+			merged[r] = append(merged[r], pixels...)
+		}
+	}
+	keys := make([]int32, 0, len(merged))
+	for r := range merged {
+		keys = append(keys, r)
+	}
+	slices.Sort(keys)
+	components := make([][]int, len(keys))
+	for i, r := range keys {
+		components[i] = merged[r]
+	}
+	return components
+}
+
+// labelBand labels the foreground pixels in rows top to bottom of classes
+// by the 4-connected piece they form within those rows, as
+// interiorByComponent describes.
+func labelBand(classes []uint8, labels []int32, width, top, bottom int) {
+	var queue []int
+	var label int32
+	visit := func(n int) {
+		if classes[n] != pixelBackground && labels[n] == 0 {
+			labels[n] = label
+			queue = append(queue, n)
+		}
+	}
+	for start := top * width; start < bottom*width; start++ {
+		if classes[start] == pixelBackground || labels[start] != 0 {
+			continue
+		}
+		label = int32(start + 1)
+		labels[start] = label
+		queue = append(queue[:0], start)
+		for len(queue) > 0 {
+			p := queue[len(queue)-1]
+			queue = queue[:len(queue)-1]
+			x := p % width
+			if x > 0 {
+				visit(p - 1)
+			}
+			if x < width-1 {
+				visit(p + 1)
+			}
+			if p-width >= top*width {
+				visit(p - width)
+			}
+			if p+width < bottom*width {
+				visit(p + width)
+			}
+		}
+	}
+}
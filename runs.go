@@ -0,0 +1,48 @@
+package main
+
+import "slices"
+
+// rowRuns holds a region's pixels as the runs they make along each row,
+// sorted left to right, so a scan line is read off as its runs and a pixel
+// is looked up by binary search rather than by one map entry per pixel.
+type rowRuns map[int][]span
+
+// newRowRuns returns the runs of points, which may come in any order.
+func newRowRuns(points []Point) rowRuns {
+	xs := make(map[int][]int)
+	for _, p := range points {
+		xs[p.y] = append(xs[p.y], p.x)
+	}
+
+	runs := make(rowRuns, len(xs))
+	for y, row := range xs {
+		slices.Sort(row)
+		var spans []span
+		for _, x := range row {
+			if n := len(spans); n > 0 && x <= spans[n-1].end+1 {
+				spans[n-1].end = max(spans[n-1].end, x)
+				continue
+			}
+			spans = append(spans, span{x, x})
+		}
+		runs[y] = spans
+	}
+	return runs
+}
+
+// contains reports whether the pixel at x, y is in the region.
+func (r rowRuns) contains(x, y int) bool {
+	row := r[y]
+	i, _ := slices.BinarySearchFunc(row, x, func(s span, x int) int { return s.end - x })
+	return i < len(row) && row[i].start <= x
+}
+
+// spans returns the runs of row y as scanSpans would find them walking the
+// row: right to left when reverse is set, each still low end first.
+func (r rowRuns) spans(y int, reverse bool) []span {
+	spans := slices.Clone(r[y])
+	if reverse {
+		slices.Reverse(spans)
+	}
+	return spans
+}
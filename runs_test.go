@@ -0,0 +1,74 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// regionPoints returns the dark pixels of a size×size speckled image as
+// one region.
+func regionPoints(size int) []Point {
+	img := speckled(size, size)
+	var points []Point
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if img.GrayAt(x, y).Y < 128 {
+				points = append(points, Point{x, y})
+			}
+		}
+	}
+	return points
+}
+
+// pixelMap is the per-pixel membership map rowRuns replaced.
+func pixelMap(points []Point) map[int]map[int]bool {
+	m := make(map[int]map[int]bool)
+	for _, p := range points {
+		if m[p.y] == nil {
+			m[p.y] = make(map[int]bool)
+		}
+		m[p.y][p.x] = true
+	}
+	return m
+}
+
+func TestRowRunsMatchPixelMap(t *testing.T) {
+	points := regionPoints(90)
+	runs, pixels := newRowRuns(points), pixelMap(points)
+	minX, minY, maxX, maxY := getBoundingBox(points)
+
+	for y := minY - 1; y <= maxY+1; y++ {
+		for _, reverse := range []bool{false, true} {
+			want := scanSpans(minX, maxX, reverse, func(x int) bool { return pixels[y][x] })
+			if got := runs.spans(y, reverse); !slices.Equal(got, want) {
+				t.Fatalf("row %d, reverse %v: runs %v, want %v", y, reverse, got, want)
+			}
+		}
+		for x := minX - 1; x <= maxX+1; x++ {
+			if runs.contains(x, y) != pixels[y][x] {
+				t.Fatalf("contains(%d, %d) = %v, want %v", x, y, runs.contains(x, y), pixels[y][x])
+			}
+		}
+	}
+}
+
+func BenchmarkRowSpans(b *testing.B) {
+	points := regionPoints(1000)
+	minX, minY, maxX, maxY := getBoundingBox(points)
+	b.Run("runs", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			runs := newRowRuns(points)
+			for y := minY; y <= maxY; y++ {
+				runs.spans(y, y%2 == 1)
+			}
+		}
+	})
+	b.Run("map", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pixels := pixelMap(points)
+			for y := minY; y <= maxY; y++ {
+				scanSpans(minX, maxX, y%2 == 1, func(x int) bool { return pixels[y][x] })
+			}
+		}
+	})
+}
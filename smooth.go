@@ -0,0 +1,32 @@
+package main
+
+// smoothChaikin rounds off the corners of the polyline pts by Chaikin corner
+// cutting, repeated iterations times: every segment is replaced by the
+// points a quarter and three quarters along it. Each round doubles the
+// point count and moves the curve no more than a quarter of a segment.
+//
+// A polyline ending where it starts is treated as a closed loop, all of its
+// corners are cut and it stays closed. An open one keeps both ends.
+func smoothChaikin(pts []vec, iterations int) []vec {
+	for ; iterations > 0 && len(pts) > 2; iterations-- {
+		closed := pts[0] == pts[len(pts)-1]
+
+		smoothed := make([]vec, 0, 2*len(pts))
+		if !closed {
+			smoothed = append(smoothed, pts[0])
+		}
+		for i := 0; i < len(pts)-1; i++ {
+			a, b := pts[i], pts[i+1]
+			smoothed = append(smoothed,
+				vec{0.75*a.x + 0.25*b.x, 0.75*a.y + 0.25*b.y},
+				vec{0.25*a.x + 0.75*b.x, 0.25*a.y + 0.75*b.y})
+		}
+		if closed {
+			smoothed = append(smoothed, smoothed[0])
+		} else {
+			smoothed = append(smoothed, pts[len(pts)-1])
+		}
+		pts = smoothed
+	}
+	return pts
+}
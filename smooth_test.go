@@ -0,0 +1,31 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// maxTurn returns the sharpest change of direction along pts, in radians.
+func maxTurn(pts []vec) float64 {
+	turn := 0.0
+	for i := 2; i < len(pts); i++ {
+		a := math.Atan2(pts[i-1].y-pts[i-2].y, pts[i-1].x-pts[i-2].x)
+		b := math.Atan2(pts[i].y-pts[i-1].y, pts[i].x-pts[i-1].x)
+		turn = math.Max(turn, math.Abs(math.Remainder(b-a, 2*math.Pi)))
+	}
+	return turn
+}
+
+func TestSmoothChaikinRoundsCorner(t *testing.T) {
+	corner := []vec{{0, 0}, {10, 0}, {10, 10}}
+	smoothed := smoothChaikin(corner, 2)
+	if len(smoothed) <= len(corner) {
+		t.Fatalf("%d points after smoothing, want more than %d", len(smoothed), len(corner))
+	}
+	if smoothed[0] != corner[0] || smoothed[len(smoothed)-1] != corner[2] {
+		t.Errorf("ends moved to %v and %v", smoothed[0], smoothed[len(smoothed)-1])
+	}
+	if before, after := maxTurn(corner), maxTurn(smoothed); after >= before {
+		t.Errorf("sharpest turn %.2f rad after smoothing, want less than %.2f", after, before)
+	}
+}
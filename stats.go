@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// JobStats summarises a job: the area the head covers, how far it moves and
+// roughly how long that takes. Distances are in the toolpath's units.
+type JobStats struct {
+	MinX, MinY, MaxX, MaxY float64
+
+	CutDistance    float64 // distance moved while cutting, arcs and plunges included
+	TravelDistance float64 // distance moved at rapid feed, the final return included
+
+	// Duration is the time the moves take at their programmed feeds.
+	// Acceleration is ignored, so real jobs run somewhat longer.
+	Duration time.Duration
+
+	// AccelDuration is the time the moves take when each one speeds up
+	// from rest and slows to a stop again at the toolpath's Accel, so short
+	// moves that never reach their feed count for what they cost. It is
+	// zero when the toolpath has no Accel.
+	AccelDuration time.Duration
+}
+
+// String formats the stats as a one-line summary.
+func (s JobStats) String() string {
+	summary := fmt.Sprintf("X %.3f to %.3f, Y %.3f to %.3f, cut %.1f, travel %.1f, about %s",
+		s.MinX, s.MaxX, s.MinY, s.MaxY, s.CutDistance, s.TravelDistance, s.Duration.Round(time.Second))
+	if s.AccelDuration > 0 {
+		summary += fmt.Sprintf(" (%s with acceleration)", s.AccelDuration.Round(time.Second))
+	}
+	return summary
+}
+
+// Stats measures the toolpath as it would run from the origin, including the
+// return to the park position at the end.
+func (tp *Toolpath) Stats() JobStats {
+	c := newStatsCounter(tp)
+	for _, m := range tp.Moves {
+		c.add(m)
+	}
+	return c.result()
+}
+
+// statsCounter accumulates JobStats one move at a time, so streamed output
+// can be measured without keeping the moves.
+type statsCounter struct {
+	tp      *Toolpath
+	x, y, z float64
+	e       extents
+	stats   JobStats
+	minutes float64
+	seconds float64 // with acceleration
+}
+
+func newStatsCounter(tp *Toolpath) *statsCounter {
+	return &statsCounter{tp: tp}
+}
+
+func (c *statsCounter) add(m Move) {
+	feed := c.tp.CutFeed
+	if m.Feed > 0 {
+		feed = m.Feed
+	}
+
+	switch m.Type {
+	case MoveTravel:
+		c.travel(math.Hypot(m.X-c.x, m.Y-c.y))
+	case MoveOverscan:
+		// Nothing is burnt, but the head runs at cutting feed.
+		d := math.Hypot(m.X-c.x, m.Y-c.y)
+		c.stats.TravelDistance += d
+		c.time(d, feed)
+	case MoveCut:
+		c.cut(math.Hypot(m.X-c.x, m.Y-c.y), feed)
+	case MoveArcCW, MoveArcCCW:
+		sweep := arcSweep(vec{c.x, c.y}, m)
+		c.cut(math.Abs(sweep)*math.Hypot(m.I, m.J), feed)
+	case MovePlunge:
+		c.cut(math.Abs(m.Z-c.z), feed)
+		c.z = m.Z
+	case MoveRetract:
+		c.travel(math.Abs(m.Z - c.z))
+		c.z = m.Z
+	case MoveDwell:
+		c.minutes += m.Time / 60
+		if c.tp.Accel > 0 {
+			c.seconds += m.Time
+		}
+	}
+
+	if m.positioned() {
+		c.x, c.y = m.X, m.Y
+		c.e.add(m.X, m.Y)
+	}
+}
+
+func (c *statsCounter) travel(d float64) {
+	c.stats.TravelDistance += d
+	c.time(d, c.tp.TravelFeed)
+}
+
+func (c *statsCounter) cut(d, feed float64) {
+	c.stats.CutDistance += d
+	c.time(d, feed)
+}
+
+// time adds a move of length d at feed per minute to the running times.
+func (c *statsCounter) time(d, feed float64) {
+	if feed <= 0 {
+		return
+	}
+	c.minutes += d / feed
+	if c.tp.Accel > 0 {
+		c.seconds += moveSeconds(d, feed/60, c.tp.Accel)
+	}
+}
+
+// moveSeconds returns how long a move of length d takes starting and ending
+// at rest, accelerating and braking at accel up to a cruise speed of speed
+// per second. A trapezoidal speed profile: moves too short to reach cruise
+// speed brake as soon as they are half done.
+func moveSeconds(d, speed, accel float64) float64 {
+	if ramp := speed * speed / accel; d < ramp {
+		// Accelerating over the first half covers d/2 = accel t²/2.
+		return 2 * math.Sqrt(d/accel)
+	}
+	return d/speed + speed/accel
+}
+
+// result returns the stats so far plus the return to the park position.
+func (c *statsCounter) result() JobStats {
+	final := *c
+	final.travel(math.Hypot(c.tp.ReturnX-c.x, c.tp.ReturnY-c.y))
+
+	s := final.stats
+	s.Duration = time.Duration(final.minutes * float64(time.Minute))
+	s.AccelDuration = time.Duration(final.seconds * float64(time.Second))
+	if c.e.valid {
+		s.MinX, s.MinY, s.MaxX, s.MaxY = c.e.minX, c.e.minY, c.e.maxX, c.e.maxY
+	}
+	return s
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStatsRectangle(t *testing.T) {
+	tp := &Toolpath{TravelFeed: 3000, CutFeed: 600}
+	tp.travel(10, 5)
+	tp.laserOn(255)
+	tp.cut(40, 5)
+	tp.cut(40, 25)
+	tp.cut(10, 25)
+	tp.cut(10, 5)
+	tp.laserOff()
+
+	s := tp.Stats()
+	if s.MinX != 10 || s.MinY != 5 || s.MaxX != 40 || s.MaxY != 25 {
+		t.Errorf("extents X %v to %v, Y %v to %v, want X 10 to 40, Y 5 to 25", s.MinX, s.MaxX, s.MinY, s.MaxY)
+	}
+	if s.CutDistance != 100 {
+		t.Errorf("cut distance %v, want the 100mm perimeter", s.CutDistance)
+	}
+	// Out to the corner and back to the origin at the end.
+	travel := 2 * math.Hypot(10, 5)
+	if math.Abs(s.TravelDistance-travel) > 1e-9 {
+		t.Errorf("travel distance %v, want %v", s.TravelDistance, travel)
+	}
+	want := time.Duration((100/600.0 + travel/3000) * float64(time.Minute))
+	if d := s.Duration - want; d < -time.Millisecond || d > time.Millisecond {
+		t.Errorf("duration %v, want %v", s.Duration, want)
+	}
+}
+
+func TestAccelDurationExceedsNaive(t *testing.T) {
+	tp := &Toolpath{TravelFeed: 3000, CutFeed: 1200, Accel: 500}
+	tp.laserOn(255)
+	for i := 1; i <= 50; i++ {
+		tp.cut(float64(i), 0)
+	}
+	tp.laserOff()
+
+	s := tp.Stats()
+	if s.AccelDuration <= s.Duration {
+		t.Errorf("fifty 1mm moves take %v with acceleration, want longer than the naive %v", s.AccelDuration, s.Duration)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"math/rand"
+)
+
+// defaultStippleDwell is how long (ms) each stipple dot burns when
+// Config.StippleDwell is 0.
+const defaultStippleDwell = 20
+
+// stipple engraves gray as a field of dots, each burnt by engaging t at a
+// point for dwell seconds, instead of outlines and fills. The image is cut
+// into cells pitchX by pitchY pixels and each cell gets a dot, somewhere
+// within it, with a chance equal to its mean darkness, levels at or above
+// background counting as white: dark areas come out dense with dots and
+// light ones sparse. Cells are visited a row at a time, alternately left to
+// right and back. The random numbers are drawn from rng, so an image
+// always converts to the same job from the same seed. It returns how many
+// dots were burnt.
+func stipple(gray *image.Gray, background uint8, offsetX, offsetY, scaleX, scaleY float64, pitchX, pitchY int, dwell float64, rng *rand.Rand, t tool, tp *Toolpath) int {
+	b := gray.Bounds()
+	cols := (b.Dx() + pitchX - 1) / pitchX
+
+	dots := 0
+	for row, y0 := 0, b.Min.Y; y0 < b.Max.Y; row, y0 = row+1, y0+pitchY {
+		y1 := min(y0+pitchY, b.Max.Y)
+		for k := 0; k < cols; k++ {
+			col := k
+			if row%2 == 1 {
+				col = cols - 1 - k
+			}
+			x0 := b.Min.X + col*pitchX
+			x1 := min(x0+pitchX, b.Max.X)
+
+			darkness := 0.0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					if v := gray.GrayAt(x, y).Y; v < background {
+						darkness += float64(255-v) / 255
+					}
+				}
+			}
+			darkness /= float64((x1 - x0) * (y1 - y0))
+
+			// Every cell draws all three numbers, so the tone of one
+			// does not move the dots of the rest.
+			chance, u, v := rng.Float64(), rng.Float64(), rng.Float64()
+			if chance >= darkness {
+				continue
+			}
+
+			// Dots land between the centres of the cell's edge pixels,
+			// so none falls outside the image.
+			x := float64(x0) + u*float64(x1-x0-1)
+			y := float64(y0) + v*float64(y1-y0-1)
+			tp.travel(offsetX+x*scaleX, offsetY+y*scaleY)
+			t.on(tp)
+			if dwell > 0 {
+				tp.dwell(dwell)
+			}
+			t.off(tp)
+			dots++
+		}
+	}
+	return dots
+}
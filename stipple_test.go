@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+// halftone returns a w×h image, level dark on its left half and light on
+// its right.
+func halftone(w, h int, dark, light uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := dark
+			if x >= w/2 {
+				v = light
+			}
+			img.SetGray(x, y, color.Gray{v})
+		}
+	}
+	return img
+}
+
+func stippleConfig(seed int64) Config {
+	cfg := DefaultConfig()
+	cfg.Stipple = true
+	cfg.Seed = seed
+	return cfg
+}
+
+func TestStippleSeed(t *testing.T) {
+	img := halftone(100, 100, 64, 192)
+	a, err := ConvertToGCode(img, stippleConfig(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ConvertToGCode(img, stippleConfig(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != b {
+		t.Error("the same seed gave different jobs")
+	}
+	c, err := ConvertToGCode(img, stippleConfig(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == c {
+		t.Error("different seeds gave the same job")
+	}
+}
+
+func TestStippleDensityFollowsTone(t *testing.T) {
+	cfg := stippleConfig(1).withDefaults()
+	img := halftone(200, 200, 32, 224)
+	dark := image.NewGray(image.Rect(0, 0, 100, 200))
+	light := image.NewGray(image.Rect(0, 0, 100, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 100; x++ {
+			dark.SetGray(x, y, img.GrayAt(x, y))
+			light.SetGray(x, y, img.GrayAt(x+100, y))
+		}
+	}
+
+	count := func(gray *image.Gray) int {
+		return stipple(gray, cfg.BackgroundThreshold, 0, 0, 1, 1, 3, 3, 0, rand.New(rand.NewSource(cfg.Seed)), newTool(cfg, 0), newToolpath(cfg))
+	}
+	d, l := count(dark), count(light)
+	if d <= 2*l {
+		t.Errorf("dark half got %d dots and light half %d, want the dark one far denser", d, l)
+	}
+}
@@ -2,32 +2,84 @@ package main
 
 import (
 	"bytes"
+	"encoding/xml"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"io"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/srwiley/oksvg"
 	"github.com/srwiley/rasterx"
 )
 
 func LoadSVG(filePath string) (image.Image, error) {
-	data, err := os.ReadFile(filePath)
+	f, err := os.Open(filePath)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
+	return DecodeSVG(f)
+}
+
+// DecodeSVG rasterizes the SVG document read from r at one pixel per
+// viewBox unit, on a white background. The image keeps the document's
+// outlines for Config.Vector.
+func DecodeSVG(r io.Reader) (image.Image, error) {
+	return DecodeSVGDPI(r, 0)
+}
+
+// DecodeSVGDPI is DecodeSVG at dpi pixels per inch of the document's
+// declared width and height. Without a physical size, viewBox units count as
+// CSS pixels, 96 to the inch. A dpi of 0 keeps one pixel per viewBox unit.
+// The drawing fills the image the same way at any dpi; only the detail
+// changes.
+//
+// A document without a viewBox is drawn in CSS pixels on a canvas of its
+// width and height, converted from whatever unit they are given in;
+// percentages, and sizes left out, are of a 300×150 canvas, as browsers
+// size an SVG with nothing to go by. A document whose size comes out zero
+// or negative is an error.
+func DecodeSVGDPI(r io.Reader, dpi float64) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
 	svgIcon, err := oksvg.ReadIconStream(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 
+	root := svgRootAttrs(data)
+	if _, ok := root["viewBox"]; !ok {
+		// oksvg takes the numbers of width and height as the viewBox
+		// whatever their unit.
+		svgIcon.ViewBox.W = svgLengthPx(root["width"], defaultCanvasWidth)
+		svgIcon.ViewBox.H = svgLengthPx(root["height"], defaultCanvasHeight)
+	}
 	viewBoxW := float64(svgIcon.ViewBox.W)
 	viewBoxH := float64(svgIcon.ViewBox.H)
+	if viewBoxW <= 0 || viewBoxH <= 0 {
+		return nil, fmt.Errorf("SVG has no usable size: viewBox %gx%g, width %q, height %q", viewBoxW, viewBoxH, root["width"], root["height"])
+	}
+
+	scale := 1.0
+	if dpi > 0 {
+		scale = dpi / 96
+		if widthMM, ok := svgLengthMM(root["width"]); ok {
+			scale = widthMM / 25.4 * dpi / viewBoxW
+		}
+	}
 
-	svgIcon.SetTarget(0, 0, viewBoxW, viewBoxH)
-	width := int(viewBoxW)
-	height := int(viewBoxH)
+	// A document under a pixel across still gets one.
+	targetW, targetH := viewBoxW*scale, viewBoxH*scale
+	svgIcon.SetTarget(0, 0, targetW, targetH)
+	width := max(int(targetW), 1)
+	height := max(int(targetH), 1)
 
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
 	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
@@ -37,5 +89,87 @@ func LoadSVG(filePath string) (image.Image, error) {
 	raster := rasterx.NewDasher(width, height, scanner)
 
 	svgIcon.Draw(raster, 1.0)
-	return img, nil
+	return &svgImage{RGBA: img, contours: svgContours(svgIcon)}, nil
+}
+
+// The canvas, in CSS pixels, an SVG is drawn on when its width and height
+// don't say: the default size of a replaced element in CSS.
+const (
+	defaultCanvasWidth  = 300
+	defaultCanvasHeight = 150
+)
+
+// svgRootAttrs returns the attributes of the document's root svg element,
+// by local name, or nil when it has none.
+func svgRootAttrs(data []byte) map[string]string {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	// Declared encodings other than UTF-8 don't matter for the attribute
+	// values looked at here.
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "svg" {
+			return nil
+		}
+		attrs := make(map[string]string, len(start.Attr))
+		for _, a := range start.Attr {
+			attrs[a.Name.Local] = a.Value
+		}
+		return attrs
+	}
+}
+
+// svgLengthPx converts the SVG length s to CSS pixels, taking percentages,
+// and an empty length, of canvas pixels. It returns 0 for a length it can't
+// read or one that isn't positive.
+func svgLengthPx(s string, canvas float64) float64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return canvas
+	}
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(strings.TrimSpace(pct), 64)
+		if err != nil || v <= 0 {
+			return 0
+		}
+		return v / 100 * canvas
+	}
+	mm, ok := svgLengthMM(s)
+	if !ok {
+		return 0
+	}
+	return mm / 25.4 * 96
+}
+
+// svgLengthMM converts an SVG length such as "50mm" or "2in" to millimetres.
+// Relative lengths (percentages, em) have no physical size.
+func svgLengthMM(s string) (float64, bool) {
+	units := map[string]float64{
+		"":   25.4 / 96,
+		"px": 25.4 / 96,
+		"pt": 25.4 / 72,
+		"pc": 25.4 / 6,
+		"mm": 1,
+		"cm": 10,
+		"in": 25.4,
+	}
+
+	s = strings.TrimSpace(s)
+	num := strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyz%")
+	mmPer, ok := units[s[len(num):]]
+	if !ok {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || v <= 0 {
+		return 0, false
+	}
+	return v * mmPer, true
 }
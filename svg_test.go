@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeSVGZeroViewBox(t *testing.T) {
+	for _, doc := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 0 0"><rect width="10" height="10"/></svg>`,
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 0"><rect width="10" height="10"/></svg>`,
+		`<svg xmlns="http://www.w3.org/2000/svg" width="0" height="0"><rect width="10" height="10"/></svg>`,
+	} {
+		if _, err := DecodeSVG(strings.NewReader(doc)); err == nil {
+			t.Errorf("no error for %s", doc)
+		}
+	}
+}
+
+func TestVectorRectCutsCorners(t *testing.T) {
+	doc := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 50 50"><rect x="10" y="5" width="30" height="20" fill="black"/></svg>`
+	img, err := DecodeSVG(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.Width, cfg.Height = 50, 50
+	cfg.Vector, cfg.NoFill = true, true
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var corners [][2]float64
+	for _, m := range tp.Moves {
+		if m.positioned() {
+			corners = append(corners, [2]float64{m.X, m.Y})
+		}
+	}
+	want := [][2]float64{{10, 5}, {40, 5}, {40, 25}, {10, 25}, {10, 5}}
+	if !slices.Equal(corners, want) {
+		t.Errorf("rectangle cut through %v, want its corners %v", corners, want)
+	}
+}
+
+func TestDecodeSVGDPIAddsDetail(t *testing.T) {
+	doc := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 40 40"><circle cx="20" cy="20" r="12" fill="black"/></svg>`
+	dark := func(dpi float64) int {
+		img, err := DecodeSVGDPI(strings.NewReader(doc), dpi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return darkPixels(ProcessImage(img, DefaultConfig()), backgroundLevel)
+	}
+	if small, large := dark(96), dark(4*96); large <= small {
+		t.Errorf("%d dark pixels at 4×, want more than the %d at 1×", large, small)
+	}
+}
+
+func TestSVGLoadersAgree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "square.svg")
+	if err := os.WriteFile(path, []byte(squareSVG), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := LoadSVG(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadImage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Bounds() != b.Bounds() || !slices.Equal(a.(*svgImage).Pix, b.(*svgImage).Pix) {
+		t.Error("LoadSVG and LoadImage rasterize the same file differently")
+	}
+}
+
+func TestDecodeSVGSizes(t *testing.T) {
+	for _, tc := range []struct {
+		attrs string
+		w, h  int
+	}{
+		{`width="50mm" height="25mm"`, 188, 94}, // 96 CSS pixels to the inch
+		{`viewBox="0 0 120 80"`, 120, 80},
+		{`width="72pt" height="1in"`, 96, 96},
+		{`width="50%" height="100%"`, 150, 150},
+	} {
+		doc := `<svg xmlns="http://www.w3.org/2000/svg" ` + tc.attrs + `><rect width="10" height="10"/></svg>`
+		img, err := DecodeSVG(strings.NewReader(doc))
+		if err != nil {
+			t.Errorf("%s: %v", tc.attrs, err)
+			continue
+		}
+		if b := img.Bounds(); b.Dx() != tc.w || b.Dy() != tc.h {
+			t.Errorf("%s: rasterized %d×%d, want %d×%d", tc.attrs, b.Dx(), b.Dy(), tc.w, tc.h)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import "image"
+
+// Adaptive threshold methods. Each pixel is compared with a local threshold
+// rather than the fixed background level: the plain mean of the window
+// around it for AdaptiveMean, or the Gaussian-weighted mean, which favours
+// the nearest pixels, for AdaptiveGaussian.
+const (
+	AdaptiveMean     = "mean"
+	AdaptiveGaussian = "gaussian"
+)
+
+// otsuLevel returns the background threshold that splits img's levels into
+// the two classes with the greatest variance between them, by Otsu's
+// method: for the dark drawing on light paper of a clean scan it falls
+// between the ink and the paper, midway across any gap in the histogram
+// there. An image of a single level has nothing to split and gets
+// backgroundLevel.
+func otsuLevel(img *image.Gray) uint8 {
+	var hist [256]int
+	for _, v := range img.Pix {
+		hist[v]++
+	}
+
+	total, sum := 0, 0
+	for level, n := range hist {
+		total += n
+		sum += level * n
+	}
+
+	// Levels no pixel has leave the split as good as it was, so the best
+	// splits run from first to last.
+	first, last, bestVariance := -1, -1, 0.0
+	dark, darkSum := 0, 0
+	for level := 0; level < 255; level++ {
+		dark += hist[level]
+		darkSum += level * hist[level]
+		light := total - dark
+		if dark == 0 || light == 0 {
+			continue
+		}
+		darkMean := float64(darkSum) / float64(dark)
+		lightMean := float64(sum-darkSum) / float64(light)
+		variance := float64(dark) * float64(light) * (darkMean - lightMean) * (darkMean - lightMean)
+		switch {
+		case variance > bestVariance:
+			first, last, bestVariance = level, level, variance
+		case variance == bestVariance && level == last+1:
+			last = level
+		}
+	}
+	if first < 0 {
+		return backgroundLevel
+	}
+	// Levels up to the split are the drawing.
+	return uint8((first+last)/2 + 1)
+}
+
+// defaultAdaptiveWindow is the default Config.AdaptiveWindow: wide enough
+// to span a pen stroke on a typical scan, narrow enough to follow shading.
+const defaultAdaptiveWindow = 31
+
+// adaptiveThreshold returns img reduced to black and white, a pixel being
+// black when it is more than offset levels darker than the mean of the
+// window×window square around it, in the manner of OpenCV's
+// adaptiveThreshold. Lighting that changes slowly across the image shifts
+// the mean with it, so a shadowed corner is split as cleanly as a lit one,
+// and flat areas stay white whatever their level. Dark areas much wider
+// than the window come out hollow for the same reason, so it should span
+// the widest strokes. With gaussian the mean is weighted by a Gaussian as
+// wide as the window, which weighs the centre more and needs a wider window.
+func adaptiveThreshold(img *image.Gray, window int, gaussian bool, offset float64) *image.Gray {
+	var mean func(x, y int) float64
+	if gaussian {
+		// OpenCV's sigma for a kernel of this size.
+		blurred := gaussianBlur(img, 0.3*(float64(window-1)/2-1)+0.8)
+		mean = func(x, y int) float64 {
+			return float64(blurred.Pix[blurred.PixOffset(x, y)])
+		}
+	} else {
+		mean = boxMean(img, window/2)
+	}
+
+	b := img.Bounds()
+	out := image.NewGray(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if float64(img.GrayAt(x, y).Y) >= mean(x, y)-offset {
+				out.Pix[out.PixOffset(x, y)] = 255
+			}
+		}
+	}
+	return out
+}
+
+// boxMean returns a function giving the mean level of the square reaching r
+// pixels either side of x, y, cut short at the border. It sums the image
+// once up front, so every window costs the same however large.
+func boxMean(img *image.Gray, r int) func(x, y int) float64 {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// sum[y*(w+1)+x] totals the pixels above and left of x, y.
+	sum := make([]int, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		row := 0
+		for x := 0; x < w; x++ {
+			row += int(img.GrayAt(b.Min.X+x, b.Min.Y+y).Y)
+			sum[(y+1)*(w+1)+x+1] = sum[y*(w+1)+x+1] + row
+		}
+	}
+
+	return func(x, y int) float64 {
+		x, y = x-b.Min.X, y-b.Min.Y
+		x0, y0 := max(x-r, 0), max(y-r, 0)
+		x1, y1 := min(x+r+1, w), min(y+r+1, h)
+		total := sum[y1*(w+1)+x1] - sum[y0*(w+1)+x1] - sum[y1*(w+1)+x0] + sum[y0*(w+1)+x0]
+		return float64(total) / float64((x1-x0)*(y1-y0))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// shadedCheckerboard returns an 8×8 checkerboard of 20-pixel squares, ink
+// at 70 on paper at 230, lit at 30% on the left rising to full on the right.
+func shadedCheckerboard() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 160, 160))
+	for y := 0; y < 160; y++ {
+		for x := 0; x < 160; x++ {
+			level := 230.0
+			if (x/20+y/20)%2 == 0 {
+				level = 70
+			}
+			img.Pix[img.PixOffset(x, y)] = uint8(level * (0.3 + 0.7*float64(x)/159))
+		}
+	}
+	return img
+}
+
+// squaresRecovered counts the squares of shadedCheckerboard whose centre
+// mask gives the right colour: black for ink, white for paper.
+func squaresRecovered(mask *image.Gray) int {
+	n := 0
+	for row := 0; row < 8; row++ {
+		for col := 0; col < 8; col++ {
+			ink := (row+col)%2 == 0
+			if black := mask.GrayAt(col*20+10, row*20+10).Y == 0; black == ink {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestAdaptiveThresholdRecoversShadedCheckerboard(t *testing.T) {
+	img := shadedCheckerboard()
+	if n := squaresRecovered(adaptiveThreshold(img, defaultAdaptiveWindow, false, 10)); n != 64 {
+		t.Errorf("adaptive threshold recovered %d of 64 squares", n)
+	}
+	if n := squaresRecovered(foregroundMask(img, otsuLevel(img))); n == 64 {
+		t.Error("the best global threshold recovered every square too; the shading proves nothing")
+	}
+}
+
+func TestOtsuLevelSplitsModes(t *testing.T) {
+	// Ink spread around 40, paper around 200.
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range img.Pix {
+		base := 200
+		if i%3 == 0 {
+			base = 40
+		}
+		img.Pix[i] = uint8(base + i%21 - 10)
+	}
+	if level := otsuLevel(img); level <= 50 || level > 190 {
+		t.Errorf("Otsu level %d, want one between the modes at 40 and 200", level)
+	}
+}
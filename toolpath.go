@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// MoveType identifies what a single toolpath step does.
+type MoveType string
+
+const (
+	MoveTravel   MoveType = "travel"    // rapid positioning with the laser off
+	MoveCut      MoveType = "cut"       // linear move at cutting feed
+	MoveLaserOn  MoveType = "laser_on"  // fire the laser at Power
+	MoveLaserOff MoveType = "laser_off" // stop the laser
+	MovePlunge   MoveType = "plunge"    // feed the Z axis down to Z
+	MoveRetract  MoveType = "retract"   // rapid the Z axis up to Z
+	MoveArcCW    MoveType = "arc_cw"    // clockwise arc at cutting feed
+	MoveArcCCW   MoveType = "arc_ccw"   // counter-clockwise arc at cutting feed
+	MovePause    MoveType = "pause"     // stop until the operator resumes, tool already off
+	MoveComment  MoveType = "comment"   // note Text in the program; does nothing
+	MoveDwell    MoveType = "dwell"     // wait Time seconds where the head is
+	MoveOverscan MoveType = "overscan"  // linear move at cutting feed with the tool off, run on past a fill line
+)
+
+// Move is one step of a toolpath. Coordinates are in output units after
+// scaling and offset and are ignored for laser moves; Z only applies to
+// plunge and retract moves. Arcs end at X, Y and have their centre at I, J
+// relative to where they start. Power applies to laser-on moves; a non-zero
+// Feed overrides the toolpath's cutting feed for a cut, arc or plunge move.
+// Text is the note a comment move carries and Time the seconds a dwell
+// move waits.
+type Move struct {
+	Type  MoveType `json:"type"`
+	X     float64  `json:"x"`
+	Y     float64  `json:"y"`
+	Z     float64  `json:"z,omitempty"`
+	I     float64  `json:"i,omitempty"`
+	J     float64  `json:"j,omitempty"`
+	Power int      `json:"power,omitempty"`
+	Feed  float64  `json:"feed,omitempty"`
+	Text  string   `json:"text,omitempty"`
+	Time  float64  `json:"time,omitempty"`
+}
+
+// Units of measure for toolpath coordinates and feeds.
+const (
+	UnitsMM   = "mm"
+	UnitsInch = "in"
+)
+
+// Toolpath is the machine-independent result of a conversion: the ordered
+// moves, their units, the default feeds used for travel and cutting moves
+// and where the head parks at the end. It holds no dialect details, so any
+// serializer can turn it into G-code. Empty Units means millimetres. Accel,
+// the machine's acceleration in units per second squared, only feeds the
+// time estimate of Stats; 0 leaves acceleration out.
+type Toolpath struct {
+	Units      string  `json:"units"`
+	TravelFeed float64 `json:"travel_feed"`
+	CutFeed    float64 `json:"cut_feed"`
+	Accel      float64 `json:"accel,omitempty"`
+	ReturnX    float64 `json:"return_x"`
+	ReturnY    float64 `json:"return_y"`
+	Moves      []Move  `json:"moves"`
+
+	// sink, when set, receives each move as it is generated instead of
+	// it being stored in Moves.
+	sink func(Move)
+}
+
+func (tp *Toolpath) add(m Move) {
+	if tp.sink != nil {
+		tp.sink(m)
+		return
+	}
+	tp.Moves = append(tp.Moves, m)
+}
+
+func (tp *Toolpath) travel(x, y float64) {
+	tp.add(Move{Type: MoveTravel, X: x, Y: y})
+}
+
+func (tp *Toolpath) cut(x, y float64) {
+	tp.add(Move{Type: MoveCut, X: x, Y: y})
+}
+
+func (tp *Toolpath) overscan(x, y float64) {
+	tp.add(Move{Type: MoveOverscan, X: x, Y: y})
+}
+
+func (tp *Toolpath) laserOn(power int) {
+	tp.add(Move{Type: MoveLaserOn, Power: power})
+}
+
+func (tp *Toolpath) laserOff() {
+	tp.add(Move{Type: MoveLaserOff})
+}
+
+func (tp *Toolpath) plunge(z, feed float64) {
+	tp.add(Move{Type: MovePlunge, Z: z, Feed: feed})
+}
+
+func (tp *Toolpath) retract(z float64) {
+	tp.add(Move{Type: MoveRetract, Z: z})
+}
+
+func (tp *Toolpath) pause() {
+	tp.add(Move{Type: MovePause})
+}
+
+func (tp *Toolpath) dwell(seconds float64) {
+	tp.add(Move{Type: MoveDwell, Time: seconds})
+}
+
+func (tp *Toolpath) comment(format string, args ...any) {
+	tp.add(Move{Type: MoveComment, Text: fmt.Sprintf(format, args...)})
+}
+
+// positioned reports whether m moves the head in X and Y.
+func (m Move) positioned() bool {
+	switch m.Type {
+	case MoveTravel, MoveCut, MoveArcCW, MoveArcCCW, MoveOverscan:
+		return true
+	}
+	return false
+}
+
+// translate shifts every positioned move by dx, dy. Arc centres are
+// relative and need no change.
+func (tp *Toolpath) translate(dx, dy float64) {
+	for i, m := range tp.Moves {
+		if m.positioned() {
+			tp.Moves[i].X += dx
+			tp.Moves[i].Y += dy
+		}
+	}
+}
+
+// extents returns the bounding box of every positioned move. Arcs count by
+// their end points; they bulge no further than the pixels they replace.
+func (tp *Toolpath) extents() extents {
+	var e extents
+	for _, m := range tp.Moves {
+		if m.positioned() {
+			e.add(m.X, m.Y)
+		}
+	}
+	return e
+}
+
+// extents tracks the bounding box of every coordinate emitted for a job.
+type extents struct {
+	minX, minY, maxX, maxY float64
+	valid                  bool
+}
+
+func (e *extents) add(x, y float64) {
+	if !e.valid {
+		e.minX, e.minY, e.maxX, e.maxY = x, y, x, y
+		e.valid = true
+		return
+	}
+
+	e.minX = math.Min(e.minX, x)
+	e.minY = math.Min(e.minY, y)
+	e.maxX = math.Max(e.maxX, x)
+	e.maxY = math.Max(e.maxY, y)
+}
+
+// frameMoves traces the bounding box with travel moves only, so the
+// placement can be checked on the workpiece before burning.
+func frameMoves(e extents) []Move {
+	if !e.valid {
+		return nil
+	}
+
+	return []Move{
+		{Type: MoveTravel, X: e.minX, Y: e.minY},
+		{Type: MoveTravel, X: e.maxX, Y: e.minY},
+		{Type: MoveTravel, X: e.maxX, Y: e.maxY},
+		{Type: MoveTravel, X: e.minX, Y: e.maxY},
+		{Type: MoveTravel, X: e.minX, Y: e.minY},
+	}
+}
+
+// ToolpathToJSON encodes tp in the intermediate JSON format.
+func ToolpathToJSON(tp *Toolpath) ([]byte, error) {
+	return json.MarshalIndent(tp, "", "  ")
+}
+
+// JSONToGCode decodes an intermediate JSON toolpath and serializes it as
+// G-code with the dialect settings in cfg, producing the same program
+// ConvertToGCode would for that cfg.
+func JSONToGCode(data []byte, cfg Config) (string, error) {
+	var tp Toolpath
+	if err := json.Unmarshal(data, &tp); err != nil {
+		return "", err
+	}
+	return tp.GCode(cfg), nil
+}
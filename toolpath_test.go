@@ -0,0 +1,143 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestFrameTracesBoundingBox(t *testing.T) {
+	img := rect(60, 40, 10, 5, 50, 30)
+	cfg := DefaultConfig()
+	job, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := job.extents()
+
+	cfg.Frame = true
+	frame, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]float64{
+		{e.minX, e.minY}, {e.maxX, e.minY}, {e.maxX, e.maxY}, {e.minX, e.maxY}, {e.minX, e.minY},
+	}
+	if len(frame.Moves) != len(want) {
+		t.Fatalf("frame has %d moves, want %d", len(frame.Moves), len(want))
+	}
+	for i, m := range frame.Moves {
+		if m.Type != MoveTravel {
+			t.Errorf("move %d is %s, want travel with the laser off", i, m.Type)
+		}
+		if m.X != want[i][0] || m.Y != want[i][1] {
+			t.Errorf("corner %d at X%v Y%v, want X%v Y%v", i, m.X, m.Y, want[i][0], want[i][1])
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	img := squares(60, 60, 15, [2]int{5, 5}, [2]int{35, 30})
+	for _, cfg := range []Config{DefaultConfig(), func() Config {
+		cfg := DefaultConfig()
+		cfg.Units, cfg.Width, cfg.Height = UnitsInch, 4, 4
+		cfg.Arcs, cfg.Passes, cfg.Comments = true, 2, true
+		return cfg
+	}()} {
+		direct, err := ConvertToGCode(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tp, err := BuildToolpath(img, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ToolpathToJSON(tp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		viaJSON, err := JSONToGCode(data, cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if viaJSON != direct {
+			t.Errorf("G-code through JSON differs from the direct conversion (units %s)", cfg.Units)
+		}
+	}
+}
+
+func TestFromContentRegistersCorner(t *testing.T) {
+	// Far more whitespace left of and above the shape than below and
+	// right of it.
+	img := rect(100, 100, 60, 70, 90, 95)
+	cfg := DefaultConfig()
+	cfg.FromContent = true
+	cfg.OffsetX, cfg.OffsetY = 5, 7
+	tp, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := tp.extents(); e.minX != 5 || e.minY != 7 {
+		t.Errorf("content corner at X%v Y%v, want X5 Y7", e.minX, e.minY)
+	}
+}
+
+func TestOffsetXShiftsOnlyX(t *testing.T) {
+	img := rect(40, 40, 10, 10, 30, 30)
+	cfg := DefaultConfig()
+	base, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.OffsetX = 12.5
+	shifted, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shifted.Moves) != len(base.Moves) {
+		t.Fatalf("offset changed the move count from %d to %d", len(base.Moves), len(shifted.Moves))
+	}
+	for i, m := range shifted.Moves {
+		b := base.Moves[i]
+		if !m.positioned() {
+			continue
+		}
+		if math.Abs(m.X-b.X-12.5) > 1e-9 || m.Y != b.Y {
+			t.Fatalf("move %d went from X%v Y%v to X%v Y%v, want only X shifted by 12.5", i, b.X, b.Y, m.X, m.Y)
+		}
+	}
+}
+
+func TestPassesRepeatOutlines(t *testing.T) {
+	// A square one pixel thick has an outline but nothing to fill.
+	img := rect(40, 40, 10, 10, 30, 30)
+	for y := 11; y < 29; y++ {
+		for x := 11; x < 29; x++ {
+			img.Pix[y*img.Stride+x] = 255
+		}
+	}
+	cfg := DefaultConfig()
+	once, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Passes = 3
+	thrice, err := BuildToolpath(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Both end by switching the laser off after the last pass.
+	n := len(once.Moves) - 1
+	if len(thrice.Moves) != 3*n+1 {
+		t.Fatalf("3 passes have %d moves, want 3×%d and the laser off", len(thrice.Moves), n)
+	}
+	for pass := 0; pass < 3; pass++ {
+		if !reflect.DeepEqual(thrice.Moves[pass*n:(pass+1)*n], once.Moves[:n]) {
+			t.Errorf("pass %d differs from the single pass", pass+1)
+		}
+	}
+	if last := thrice.Moves[3*n]; last.Type != MoveLaserOff {
+		t.Errorf("3 passes end with a %v move, want the laser off", last.Type)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// RotateImage returns img turned clockwise (as the image is viewed) by
+// degrees. Right angles are exact pixel moves; any other angle is
+// resampled bilinearly onto a canvas large enough for the whole image, with
+// the uncovered corners filled white so they are never engraved.
+func RotateImage(img image.Image, degrees float64) image.Image {
+	degrees = math.Mod(degrees, 360)
+	if degrees < 0 {
+		degrees += 360
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	at := func(x, y int) color.Color { return img.At(b.Min.X+x, b.Min.Y+y) }
+
+	var out *image.RGBA
+	switch degrees {
+	case 0:
+		return img
+	case 90:
+		out = image.NewRGBA(image.Rect(0, 0, h, w))
+		for y := 0; y < w; y++ {
+			for x := 0; x < h; x++ {
+				out.Set(x, y, at(y, h-1-x))
+			}
+		}
+	case 180:
+		out = image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				out.Set(x, y, at(w-1-x, h-1-y))
+			}
+		}
+	case 270:
+		out = image.NewRGBA(image.Rect(0, 0, h, w))
+		for y := 0; y < w; y++ {
+			for x := 0; x < h; x++ {
+				out.Set(x, y, at(w-1-y, x))
+			}
+		}
+	default:
+		out = rotateBilinear(img, degrees)
+	}
+	return out
+}
+
+// MirrorImage returns img flipped left to right when horizontal is set and
+// top to bottom when vertical is set, for engraving the back of clear
+// stock or making stamps.
+func MirrorImage(img image.Image, horizontal, vertical bool) image.Image {
+	if !horizontal && !vertical {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := y
+		if vertical {
+			sy = h - 1 - y
+		}
+		for x := 0; x < w; x++ {
+			sx := x
+			if horizontal {
+				sx = w - 1 - x
+			}
+			out.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return out
+}
+
+// rotateBilinear rotates img clockwise by degrees about its centre,
+// sampling each destination pixel from the four source pixels around it.
+func rotateBilinear(img image.Image, degrees float64) *image.RGBA {
+	b := img.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	rad := degrees * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	outW, outH := rotatedSize(w, h, degrees)
+
+	out := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(outW)), int(math.Ceil(outH))))
+	ocx, ocy := float64(out.Rect.Dx())/2, float64(out.Rect.Dy())/2
+
+	// sample returns a source channel value, white beyond the edges.
+	sample := func(x, y int) [4]float64 {
+		if x < 0 || y < 0 || x >= b.Dx() || y >= b.Dy() {
+			return [4]float64{0xffff, 0xffff, 0xffff, 0xffff}
+		}
+		r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+		return [4]float64{float64(r), float64(g), float64(bl), float64(a)}
+	}
+
+	for y := 0; y < out.Rect.Dy(); y++ {
+		for x := 0; x < out.Rect.Dx(); x++ {
+			// Map the pixel centre back into the source by the inverse
+			// rotation, in pixel-centre coordinates.
+			dx, dy := float64(x)+0.5-ocx, float64(y)+0.5-ocy
+			sx := dx*cos + dy*sin + w/2 - 0.5
+			sy := -dx*sin + dy*cos + h/2 - 0.5
+
+			x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+			fx, fy := sx-float64(x0), sy-float64(y0)
+			p00, p10 := sample(x0, y0), sample(x0+1, y0)
+			p01, p11 := sample(x0, y0+1), sample(x0+1, y0+1)
+
+			var c [4]uint16
+			for i := range c {
+				top := p00[i]*(1-fx) + p10[i]*fx
+				bottom := p01[i]*(1-fx) + p11[i]*fx
+				c[i] = uint16(math.Round(top*(1-fy) + bottom*fy))
+			}
+			out.Set(x, y, color.RGBA64{c[0], c[1], c[2], c[3]})
+		}
+	}
+
+	return out
+}
+
+// rotatedSize returns the bounding box of a w by h rectangle turned by
+// degrees. It maps engraving sizes as well as pixel sizes, so a rotated
+// image keeps its scale. Right angles are exact.
+func rotatedSize(w, h, degrees float64) (float64, float64) {
+	switch math.Abs(math.Mod(degrees, 180)) {
+	case 0:
+		return w, h
+	case 90:
+		return h, w
+	}
+
+	rad := degrees * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(rad)), math.Abs(math.Cos(rad))
+	return w*cos + h*sin, w*sin + h*cos
+}
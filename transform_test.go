@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// numbered returns a w×h image whose every pixel has its own gray level.
+func numbered(w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(10 * (i + 1))
+	}
+	return img
+}
+
+// grayAt returns the gray level of img at x, y.
+func grayAt(img image.Image, x, y int) uint8 {
+	b := img.Bounds()
+	return color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y
+}
+
+func TestRotateImageRightAngles(t *testing.T) {
+	const w, h = 4, 3
+	src := numbered(w, h)
+	tests := []struct {
+		degrees float64
+		w, h    int
+		at      func(x, y int) (int, int) // where source pixel x, y lands
+	}{
+		{90, h, w, func(x, y int) (int, int) { return h - 1 - y, x }},
+		{180, w, h, func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }},
+		{270, h, w, func(x, y int) (int, int) { return y, w - 1 - x }},
+		{-90, h, w, func(x, y int) (int, int) { return y, w - 1 - x }},
+	}
+	for _, tt := range tests {
+		got := RotateImage(src, tt.degrees)
+		if b := got.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+			t.Errorf("%v°: rotated to %d×%d, want %d×%d", tt.degrees, b.Dx(), b.Dy(), tt.w, tt.h)
+			continue
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				nx, ny := tt.at(x, y)
+				if g, want := grayAt(got, nx, ny), src.GrayAt(x, y).Y; g != want {
+					t.Errorf("%v°: pixel %d,%d landed elsewhere than %d,%d", tt.degrees, x, y, nx, ny)
+				}
+			}
+		}
+	}
+}
+
+func TestMirrorImage(t *testing.T) {
+	const w, h = 4, 3
+	src := numbered(w, h)
+	corner := src.GrayAt(0, 0).Y
+	for _, tt := range []struct {
+		horizontal, vertical bool
+		x, y                 int // where the pixel at 0,0 lands
+	}{
+		{true, false, w - 1, 0},
+		{false, true, 0, h - 1},
+		{true, true, w - 1, h - 1},
+		{false, false, 0, 0},
+	} {
+		got := MirrorImage(src, tt.horizontal, tt.vertical)
+		if got.Bounds().Dx() != w || got.Bounds().Dy() != h {
+			t.Errorf("mirrorx %v, mirrory %v: size changed to %v", tt.horizontal, tt.vertical, got.Bounds())
+			continue
+		}
+		if v := grayAt(got, tt.x, tt.y); v != corner {
+			t.Errorf("mirrorx %v, mirrory %v: pixel 0,0 did not land at %d,%d", tt.horizontal, tt.vertical, tt.x, tt.y)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "math"
+
+// travelGuard makes sure the tool is disengaged for every long rapid: a
+// travel longer than limit with the laser on is preceded by switching it
+// off, or in CNC mode with the cutter down by retracting it to safeZ, and
+// followed by engaging it again just as it was. GRBL's laser mode keeps
+// the beam off during G0 anyway, but other controllers do not, and a
+// rapid with the beam on scorches a line across the work. A nil guard
+// passes every move through.
+type travelGuard struct {
+	limit float64
+	cnc   bool
+	safeZ float64
+
+	x, y    float64
+	engaged bool
+	on      Move // the move that engaged the tool
+}
+
+// newTravelGuard returns the guard for cfg's TravelSafe, or nil when it is
+// not set.
+func newTravelGuard(cfg Config) *travelGuard {
+	if cfg.TravelSafe <= 0 {
+		return nil
+	}
+	return &travelGuard{limit: cfg.TravelSafe, cnc: cfg.CNC, safeZ: cfg.SafeZ}
+}
+
+// add passes m to emit, with the moves disengaging and re-engaging the
+// tool around it when it is a long travel.
+func (g *travelGuard) add(m Move, emit func(Move)) {
+	if g == nil {
+		emit(m)
+		return
+	}
+
+	switch m.Type {
+	case MoveLaserOn, MovePlunge:
+		g.engaged, g.on = true, m
+	case MoveLaserOff, MoveRetract:
+		g.engaged = false
+	case MoveTravel:
+		if g.engaged && math.Hypot(m.X-g.x, m.Y-g.y) > g.limit {
+			if g.cnc {
+				emit(Move{Type: MoveRetract, Z: g.safeZ})
+			} else {
+				emit(Move{Type: MoveLaserOff})
+			}
+			emit(m)
+			emit(g.on)
+			g.x, g.y = m.X, m.Y
+			return
+		}
+	}
+	if m.positioned() {
+		g.x, g.y = m.X, m.Y
+	}
+	emit(m)
+}
+
+// filterMoves runs every move of a built toolpath through the guard.
+func (g *travelGuard) filterMoves(moves []Move) []Move {
+	if g == nil {
+		return moves
+	}
+	guarded := make([]Move, 0, len(moves))
+	for _, m := range moves {
+		g.add(m, func(m Move) { guarded = append(guarded, m) })
+	}
+	return guarded
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTravelGuardLongRapid(t *testing.T) {
+	on := Move{Type: MoveLaserOn, Power: 1000}
+	moves := []Move{
+		{Type: MoveTravel, X: 0, Y: 0},
+		on,
+		{Type: MoveCut, X: 10, Y: 0},
+		{Type: MoveTravel, X: 11, Y: 0},
+		{Type: MoveCut, X: 20, Y: 0},
+		{Type: MoveTravel, X: 80, Y: 0},
+		{Type: MoveCut, X: 90, Y: 0},
+	}
+	got := newTravelGuard(Config{TravelSafe: 5}).filterMoves(moves)
+	want := []Move{
+		{Type: MoveTravel, X: 0, Y: 0},
+		on,
+		{Type: MoveCut, X: 10, Y: 0},
+		{Type: MoveTravel, X: 11, Y: 0},
+		{Type: MoveCut, X: 20, Y: 0},
+		{Type: MoveLaserOff},
+		{Type: MoveTravel, X: 80, Y: 0},
+		on,
+		{Type: MoveCut, X: 90, Y: 0},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got  %+v\nwant %+v", got, want)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/math/fixed"
+)
+
+// svgImage is a rasterized SVG that remembers the vector outlines it was
+// drawn from, so Config.Vector can cut them instead of tracing pixels.
+type svgImage struct {
+	*image.RGBA
+
+	// contours holds every subpath of the document flattened to line
+	// segments, in pixels. Closed subpaths end where they
+	// start.
+	contours [][]vec
+}
+
+// svgContours flattens every path of icon, with its transforms applied, into
+// polylines. Curves are flattened by rasterx to well under a pixel. Strokes
+// are followed along their centre line rather than around their outline, and
+// every path counts whatever its paint, so the result is the line art as
+// drawn. icon's paint is changed in the process.
+func svgContours(icon *oksvg.SvgIcon) [][]vec {
+	rec := &contourRecorder{}
+	dasher := rasterx.NewDasher(0, 0, rec)
+
+	var contours [][]vec
+	for i := range icon.SVGPaths {
+		svgPath := &icon.SVGPaths[i]
+
+		// Drawing the path as a plain fill hands its flattened, transformed
+		// points straight to the scanner, with no stroke outline around it.
+		svgPath.SetFillColor(color.Black)
+		svgPath.SetLineColor(nil)
+
+		rec.contours = nil
+		svgPath.DrawTransformed(dasher, 1, icon.Transform)
+
+		open := openSubpaths(svgPath.Path)
+		for j, c := range rec.contours {
+			// The filler closes every subpath back to its start; open
+			// ones lose that last segment again.
+			if j < len(open) && open[j] {
+				c = c[:len(c)-1]
+			}
+			if len(c) > 1 {
+				contours = append(contours, c)
+			}
+		}
+	}
+	return contours
+}
+
+// openSubpaths reports, for each subpath of p in order, whether it is left
+// open away from its start, so drawing it adds a closing segment that is not
+// part of the drawing.
+func openSubpaths(p rasterx.Path) []bool {
+	var open []bool
+	var start, last fixed.Point26_6
+	closed := false
+	finish := func() {
+		if len(open) > 0 {
+			open[len(open)-1] = !closed && last != start
+		}
+	}
+
+	for i := 0; i < len(p); {
+		switch rasterx.PathCommand(p[i]) {
+		case rasterx.PathMoveTo:
+			finish()
+			start = fixed.Point26_6{X: p[i+1], Y: p[i+2]}
+			last, closed = start, false
+			open = append(open, false)
+			i += 3
+		case rasterx.PathLineTo:
+			last = fixed.Point26_6{X: p[i+1], Y: p[i+2]}
+			i += 3
+		case rasterx.PathQuadTo:
+			last = fixed.Point26_6{X: p[i+3], Y: p[i+4]}
+			i += 5
+		case rasterx.PathCubicTo:
+			last = fixed.Point26_6{X: p[i+5], Y: p[i+6]}
+			i += 7
+		case rasterx.PathClose:
+			closed = true
+			i++
+		default:
+			return open
+		}
+	}
+	finish()
+	return open
+}
+
+// contourRecorder is a rasterx.Scanner that keeps the polylines it is given
+// instead of rasterizing them. Each Start begins a new polyline.
+type contourRecorder struct {
+	contours [][]vec
+}
+
+func (r *contourRecorder) Start(a fixed.Point26_6) {
+	r.contours = append(r.contours, []vec{fixedVec(a)})
+}
+
+func (r *contourRecorder) Line(b fixed.Point26_6) {
+	n := len(r.contours) - 1
+	if n < 0 {
+		return
+	}
+	p := fixedVec(b)
+	if c := r.contours[n]; c[len(c)-1] != p {
+		r.contours[n] = append(c, p)
+	}
+}
+
+func (r *contourRecorder) Draw()                              {}
+func (r *contourRecorder) GetPathExtent() fixed.Rectangle26_6 { return fixed.Rectangle26_6{} }
+func (r *contourRecorder) SetBounds(width, height int)        {}
+func (r *contourRecorder) SetColor(clr interface{})           {}
+func (r *contourRecorder) SetWinding(useNonZeroWinding bool)  {}
+func (r *contourRecorder) Clear()                             {}
+func (r *contourRecorder) SetClip(rect image.Rectangle)       {}
+
+func fixedVec(p fixed.Point26_6) vec {
+	return vec{float64(p.X) / 64, float64(p.Y) / 64}
+}
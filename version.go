@@ -0,0 +1,31 @@
+package main
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// version is the release this build reports with -version, set at build
+// time with -ldflags "-X main.version=v1.2.3". When it is not set the
+// module version or VCS revision from the build info is used instead.
+var version string
+
+// buildVersion returns the version string -version prints: the release,
+// and the Go version the binary was built with.
+func buildVersion() string {
+	v := version
+	if info, ok := debug.ReadBuildInfo(); ok && v == "" {
+		if info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			if v == "" && s.Key == "vcs.revision" {
+				v = "devel " + s.Value[:min(len(s.Value), 12)]
+			}
+		}
+	}
+	if v == "" {
+		v = "devel"
+	}
+	return v + " (" + runtime.Version() + ")"
+}